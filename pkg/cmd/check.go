@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/spf13/cobra"
+)
+
+func (cmd Cmd) newCheckCmd(o *rootCmdOptions) *cobra.Command {
+	var co externalforwarder.CheckOption
+	c := &cobra.Command{
+		Use:   "check [flags] [QUALIFIERS:][[LOCAL_HOST:]LOCAL_PORT:]REMOTE_HOST:REMOTE_PORT...",
+		Short: "Verify the remote hosts are reachable from the pod",
+		Long: `Verify the remote hosts are reachable from the pod.
+It exits with status 1 if any remote host is unreachable.`,
+		Example: `kubectl external-forward check db.staging:5432 --tls api.staging:443`,
+		Args:    cobra.ArbitraryArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runCheckCmd(c.Context(), *o, co, args, c.OutOrStdout())
+		},
+	}
+	c.Flags().StringVar(&o.profile, "profile", "", "Path to a profile file which contains the tunnels")
+	c.Flags().BoolVar(&co.TLS, "tls", false, "Perform a TLS handshake with the remote hosts")
+	return c
+}
+
+func (cmd Cmd) runCheckCmd(ctx context.Context, o rootCmdOptions, co externalforwarder.CheckOption, args []string, w io.Writer) error {
+	eo, err := o.externalForwarderOption(args)
+	if err != nil {
+		return err
+	}
+	results, err := cmd.ExternalForwarder.Check(ctx, eo, co)
+	if err != nil {
+		return err
+	}
+	if err := writeCheckResults(w, results); err != nil {
+		return err
+	}
+	var failures int
+	for _, r := range results {
+		if r.Err != nil {
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d remote hosts are unreachable", failures, len(results))
+	}
+	return nil
+}
+
+func writeCheckResults(w io.Writer, results []externalforwarder.CheckResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "REMOTE\tRESULT\tLATENCY\tERROR")
+	for _, r := range results {
+		remote := fmt.Sprintf("%s:%d", r.Tunnel.RemoteHost, r.Tunnel.RemotePort)
+		if r.Err != nil {
+			_, _ = fmt.Fprintf(tw, "%s\tFAIL\t-\t%s\n", remote, r.Err)
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "%s\tOK\t%s\t\n", remote, r.Latency.Round(time.Millisecond))
+	}
+	return tw.Flush()
+}
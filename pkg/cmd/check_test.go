@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+func TestWriteCheckResults(t *testing.T) {
+	results := []externalforwarder.CheckResult{
+		{
+			Tunnel:  tunnel.Tunnel{RemoteHost: "db.staging", RemotePort: 5432},
+			Latency: 23456 * time.Microsecond,
+		},
+		{
+			Tunnel: tunnel.Tunnel{RemoteHost: "api.staging", RemotePort: 443},
+			Err:    errors.New("tcp: could not connect to the remote host"),
+		},
+	}
+	var b strings.Builder
+	if err := writeCheckResults(&b, results); err != nil {
+		t.Fatalf("writeCheckResults error: %s", err)
+	}
+	want := `REMOTE           RESULT  LATENCY  ERROR
+db.staging:5432  OK      23ms     
+api.staging:443  FAIL    -        tcp: could not connect to the remote host
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -6,21 +6,30 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
-	"strconv"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/google/wire"
+	"github.com/int128/kubectl-external-forward/pkg/daemon"
+	"github.com/int128/kubectl-external-forward/pkg/event"
 	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
 	"github.com/int128/kubectl-external-forward/pkg/tunnel"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
 
 const (
-	defaultImage = "ghcr.io/int128/kubectl-external-forward/mirror/envoy"
+	defaultImage           = externalforwarder.DefaultPodImage
+	defaultPolicyConfigMap = externalforwarder.DefaultPolicyConfigMap
+	defaultLazyIdleTimeout = 10 * time.Minute
+	defaultDrainTimeout    = 30 * time.Second
 )
 
 var Set = wire.NewSet(
@@ -39,7 +48,7 @@ type Cmd struct {
 
 // Run parses the arguments and executes the corresponding use-case.
 func (cmd Cmd) Run(ctx context.Context, osArgs []string, version string) int {
-	rootCmd := cmd.newRootCmd()
+	rootCmd := cmd.newRootCmd(version)
 	rootCmd.SilenceErrors = true
 	rootCmd.SilenceUsage = true
 	rootCmd.Version = version
@@ -58,31 +67,87 @@ func (cmd Cmd) Run(ctx context.Context, osArgs []string, version string) int {
 }
 
 type rootCmdOptions struct {
-	k8sOptions     *genericclioptions.ConfigFlags
-	localPort      int
-	remoteHostPort string
-	image          string
+	k8sOptions      *genericclioptions.ConfigFlags
+	localPort       int
+	remoteHostPort  string
+	image           string
+	loopbackAlias   bool
+	hostsFile       string
+	dnsServerAddr   string
+	profile         string
+	connection      tunnel.ConnectionOptions
+	accessLogFile   string
+	metricsAddr     string
+	adminPort       int
+	check           bool
+	checkTLS        bool
+	output          string
+	policyConfigMap string
+	networkPolicy   bool
+	allowUnresolved bool
+	egressCIDRs     []string
+	onTunnelFailure string
+	lazy            bool
+	lazyIdleTimeout time.Duration
+	drainTimeout    time.Duration
+	detach          bool
+	tui             bool
+	version         string
 }
 
 func (o *rootCmdOptions) addFlags(f *pflag.FlagSet) {
 	o.k8sOptions.AddFlags(f)
 }
 
-func (cmd Cmd) newRootCmd() *cobra.Command {
+func (cmd Cmd) newRootCmd(version string) *cobra.Command {
 	var o rootCmdOptions
+	o.version = version
 	o.k8sOptions = genericclioptions.NewConfigFlags(false)
 	c := &cobra.Command{
-		Use:     "kubectl external-forward [flags] [LOCAL_HOST:]LOCAL_PORT:REMOTE_HOST:REMOTE_PORT...",
-		Short:   "TODO",
-		Example: `kubectl external-forward 10000:db.staging:5432`,
+		Use:   "kubectl external-forward [flags] [QUALIFIERS:][[LOCAL_HOST:]LOCAL_PORT:]REMOTE_HOST:REMOTE_PORT...",
+		Short: "TODO",
+		Example: `kubectl external-forward 10000:db.staging:5432
+kubectl external-forward ctx=prod-eu,ns=tools:15432:db:5432 ctx=staging:15433:db:5432`,
+		Args: cobra.ArbitraryArgs,
 		RunE: func(c *cobra.Command, args []string) error {
-			return cmd.runRootCmd(c.Context(), o, args)
+			return cmd.runRootCmd(c.Context(), o, args, c.Flags(), c.OutOrStdout())
 		},
 	}
-	o.addFlags(c.Flags())
+	o.addFlags(c.PersistentFlags())
 	c.Flags().IntVarP(&o.localPort, "local-port", "l", 0, "local port")
 	c.Flags().StringVarP(&o.remoteHostPort, "remote-host", "r", "", "remote host:port")
-	c.Flags().StringVarP(&o.image, "image", "", defaultImage, "Pod image")
+	c.PersistentFlags().StringVarP(&o.image, "image", "", defaultImage, "Pod image")
+	c.PersistentFlags().StringVar(&o.policyConfigMap, "policy-configmap", defaultPolicyConfigMap, "ConfigMap of the destination policy in form of NAMESPACE/NAME. If empty, do not read the policy")
+	c.Flags().StringVar(&o.profile, "profile", "", "Path to a profile file which contains the tunnels")
+	c.Flags().BoolVar(&o.loopbackAlias, "loopback-alias", false, "Listen on a distinct loopback address (127.0.0.x) for each remote host. On macOS, the aliases must be added by ifconfig lo0 alias")
+	c.Flags().StringVar(&o.hostsFile, "hosts-file", "", "If set, add the remote hostnames to the hosts file (e.g. /etc/hosts) until exit")
+	c.Flags().StringVar(&o.dnsServerAddr, "dns-listen", "", "If set, run a DNS server which answers the remote hostnames on the address (e.g. 127.0.0.1:5353)")
+	c.Flags().DurationVar(&o.connection.ConnectTimeout, "connect-timeout", 0, "Timeout for connecting to a remote host (default 30s)")
+	c.Flags().DurationVar(&o.connection.IdleTimeout, "idle-timeout", 0, "If set, close a connection idle for the duration")
+	c.Flags().IntVar(&o.connection.MaxConnections, "max-connections", 0, "If set, limit the number of connections to a remote host")
+	c.Flags().IntVar(&o.connection.MaxPendingRequests, "max-pending-requests", 0, "If set, limit the number of connections waiting for a remote host")
+	c.Flags().BoolVar(&o.connection.TCPKeepalive, "tcp-keepalive", false, "Enable TCP keepalive on the connections to a remote host")
+	c.Flags().StringVar(&o.accessLogFile, "access-log", "", "If set, write the access logs of connections into the file in JSON lines")
+	c.Flags().StringVar(&o.metricsAddr, "metrics-addr", "", "If set, serve Prometheus metrics on the address (e.g. 127.0.0.1:9090)")
+	c.Flags().IntVar(&o.adminPort, "admin-port", 0, "If set, forward the local port to the admin interface of Envoy in the pod")
+	c.Flags().BoolVar(&o.check, "check", false, "Verify the remote hosts from the pod before starting the port-forwarders")
+	c.Flags().BoolVar(&o.checkTLS, "check-tls", false, "Perform a TLS handshake with the remote hosts on --check")
+	c.Flags().BoolVar(&o.networkPolicy, "network-policy", false, "Create a network policy which allows the pod to connect only to the remote hosts and DNS")
+	c.Flags().BoolVar(&o.allowUnresolved, "allow-unresolved", false, "On --network-policy, allow the port of a remote host to any address if the CIDRs are unknown")
+	c.Flags().StringArrayVar(&o.egressCIDRs, "egress-cidr", nil, "On --network-policy, allow the remote host by the CIDR in form of HOST=CIDR (can be repeated)")
+	c.Flags().StringVarP(&o.output, "output", "o", "", "If json, write the events to stdout in JSON lines")
+	c.Flags().StringVar(&o.onTunnelFailure, "on-tunnel-failure", string(externalforwarder.AbortOnTunnelFailure), "Behavior when a tunnel fails: abort (stop all tunnels), continue (keep the other tunnels) or retry (restart the tunnel)")
+	c.Flags().BoolVar(&o.lazy, "lazy", false, "Create the pod on the first connection and delete it when idle")
+	c.Flags().DurationVar(&o.lazyIdleTimeout, "lazy-idle-timeout", defaultLazyIdleTimeout, "Delete the pod when no connection is active for the duration on --lazy")
+	c.Flags().DurationVar(&o.drainTimeout, "drain-timeout", defaultDrainTimeout, "On interrupt, wait for the active connections to finish up to the duration. If 0, stop immediately")
+	c.Flags().BoolVar(&o.detach, "detach", false, "Run in background and exit when the tunnels are ready. See ps, stop and logs commands")
+	c.Flags().BoolVar(&o.tui, "tui", false, "Show an interactive dashboard of the tunnels instead of the logs")
+	c.AddCommand(cmd.newStdioCmd(&o))
+	c.AddCommand(cmd.newCheckCmd(&o))
+	c.AddCommand(cmd.newDebugCmd())
+	c.AddCommand(cmd.newPsCmd())
+	c.AddCommand(cmd.newStopCmd())
+	c.AddCommand(cmd.newLogsCmd())
 
 	gf := flag.NewFlagSet("", flag.ContinueOnError)
 	klog.InitFlags(gf)
@@ -90,59 +155,238 @@ func (cmd Cmd) newRootCmd() *cobra.Command {
 	return c
 }
 
-func (cmd Cmd) runRootCmd(ctx context.Context, o rootCmdOptions, args []string) error {
+func (cmd Cmd) runRootCmd(ctx context.Context, o rootCmdOptions, args []string, flags *pflag.FlagSet, stdout io.Writer) error {
+	eo, err := o.externalForwarderOption(args)
+	if err != nil {
+		return err
+	}
+	switch o.output {
+	case "":
+	case "json":
+		eo.Events = event.NewJSONEmitter(stdout)
+		// stdout is reserved for the events
+		eo.PortForwarderOut = io.Discard
+	default:
+		return fmt.Errorf("invalid --output %s: must be json", o.output)
+	}
+	if o.check || o.checkTLS {
+		eo.Check = &externalforwarder.CheckOption{TLS: o.checkTLS}
+	}
+	if o.lazy {
+		eo.Lazy = &externalforwarder.LazyOption{IdleTimeout: o.lazyIdleTimeout}
+	}
+	if o.tui {
+		if o.output != "" || o.detach {
+			return fmt.Errorf("--tui cannot be used with --output or --detach")
+		}
+		return cmd.runTUI(ctx, eo)
+	}
+	if o.detach {
+		if id := os.Getenv(daemon.IDEnv); id != "" {
+			return cmd.runDaemon(ctx, id, detachArgs(flags, args), eo)
+		}
+		return detach(ctx, detachArgs(flags, args), stdout)
+	}
+	return cmd.ExternalForwarder.Do(ctx, eo)
+}
+
+// externalForwarderOption builds an option from the arguments, profile and flags.
+func (o rootCmdOptions) externalForwarderOption(args []string) (externalforwarder.Option, error) {
+	onTunnelFailure, err := parseTunnelFailurePolicy(o.onTunnelFailure)
+	if err != nil {
+		return externalforwarder.Option{}, err
+	}
+	egressCIDRs, err := parseEgressCIDRs(o.egressCIDRs)
+	if err != nil {
+		return externalforwarder.Option{}, err
+	}
 	tunnels, err := parseTunnelArgs(args)
 	if err != nil {
-		return fmt.Errorf("invalid arguments: %w", err)
+		return externalforwarder.Option{}, fmt.Errorf("invalid arguments: %w", err)
+	}
+	defaults := o.connection
+	if o.profile != "" {
+		p, err := loadProfile(o.profile)
+		if err != nil {
+			return externalforwarder.Option{}, fmt.Errorf("invalid profile: %w", err)
+		}
+		profileTunnels, err := p.toTunnels()
+		if err != nil {
+			return externalforwarder.Option{}, fmt.Errorf("invalid profile: %w", err)
+		}
+		tunnels = append(tunnels, profileTunnels...)
+		profileDefaults, err := p.Defaults.toConnectionOptions()
+		if err != nil {
+			return externalforwarder.Option{}, fmt.Errorf("invalid profile: defaults: %w", err)
+		}
+		defaults = defaults.WithDefaults(profileDefaults)
+	}
+	if len(tunnels) < 1 {
+		return externalforwarder.Option{}, fmt.Errorf("you need to specify one or more arguments or --profile")
 	}
+	for i := range tunnels {
+		tunnels[i].ConnectionOptions = tunnels[i].ConnectionOptions.WithDefaults(defaults)
+	}
+	tunnels = assignLocalHosts(tunnels, o.loopbackAlias)
+	tunnels = tunnel.AllocateContainerPorts(tunnels)
 	restConfig, err := o.k8sOptions.ToRESTConfig()
 	if err != nil {
-		return fmt.Errorf("could not load the config: %w", err)
+		return externalforwarder.Option{}, fmt.Errorf("could not load the config: %w", err)
 	}
 	namespace, _, err := o.k8sOptions.ToRawKubeConfigLoader().Namespace()
 	if err != nil {
-		return fmt.Errorf("could not determine the namespace: %w", err)
-	}
-	return cmd.ExternalForwarder.Do(ctx, externalforwarder.Option{
-		Config:    restConfig,
-		Tunnels:   tunnels,
-		Namespace: namespace,
-		PodImage:  o.image,
-	})
+		return externalforwarder.Option{}, fmt.Errorf("could not determine the namespace: %w", err)
+	}
+	contextConfigs, tunnels, err := resolveContexts(o, tunnels)
+	if err != nil {
+		return externalforwarder.Option{}, err
+	}
+	return externalforwarder.Option{
+		Config:          restConfig,
+		Namespace:       namespace,
+		ContextConfigs:  contextConfigs,
+		Tunnels:         tunnels,
+		PodImage:        o.image,
+		HostsFile:       o.hostsFile,
+		DNSServerAddr:   o.dnsServerAddr,
+		AccessLogFile:   o.accessLogFile,
+		MetricsAddr:     o.metricsAddr,
+		AdminPort:       o.adminPort,
+		PolicyConfigMap: o.policyConfigMap,
+		NetworkPolicy:   o.networkPolicy,
+		AllowUnresolved: o.allowUnresolved,
+		EgressCIDRs:     egressCIDRs,
+		Version:         o.version,
+		KubeconfigUser:  kubeconfigUser(o),
+		OnTunnelFailure: onTunnelFailure,
+		DrainTimeout:    o.drainTimeout,
+	}, nil
 }
 
-func parseTunnelArgs(args []string) ([]tunnel.Tunnel, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("you need to specify one or more arguments")
+func parseTunnelFailurePolicy(s string) (externalforwarder.TunnelFailurePolicy, error) {
+	if s == "" {
+		return externalforwarder.AbortOnTunnelFailure, nil
 	}
-	var tunnels []tunnel.Tunnel
-	for _, arg := range args {
-		s := strings.Split(arg, ":")
-		lh := "127.0.0.1"
-		if len(s) > 4 || len(s) < 3 {
-			return nil, fmt.Errorf("invalid argument %s", arg)
+	var names []string
+	for _, p := range externalforwarder.TunnelFailurePolicies {
+		if string(p) == s {
+			return p, nil
 		}
-		if len(s) == 4 {
-			if net.ParseIP(s[0]) == nil {
-				return nil, fmt.Errorf("invalid local host: %s", s[0])
-			}
-			lh = s[0]
-			s = s[1:]
+		names = append(names, string(p))
+	}
+	return "", fmt.Errorf("invalid --on-tunnel-failure %s: must be one of %s", s, strings.Join(names, ", "))
+}
+
+// parseEgressCIDRs parses the values of HOST=CIDR into the map of host to CIDRs.
+func parseEgressCIDRs(values []string) (map[string][]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	m := make(map[string][]string)
+	for _, v := range values {
+		s := strings.SplitN(v, "=", 2)
+		if len(s) != 2 || s[0] == "" {
+			return nil, fmt.Errorf("invalid --egress-cidr %s: must be in form of HOST=CIDR", v)
 		}
-		l, err := strconv.Atoi(s[0])
+		_, ipNet, err := net.ParseCIDR(s[1])
 		if err != nil {
-			return nil, fmt.Errorf("invalid local port: %w", err)
+			return nil, fmt.Errorf("invalid --egress-cidr %s: %w", v, err)
 		}
-		r, err := strconv.Atoi(s[2])
-		if err != nil {
-			return nil, fmt.Errorf("invalid local port: %w", err)
+		m[s[0]] = append(m[s[0]], ipNet.String())
+	}
+	return m, nil
+}
+
+// kubeconfigUser returns the name of user in the current context of kubeconfig.
+// It returns empty if not found.
+func kubeconfigUser(o rootCmdOptions) string {
+	if o.k8sOptions.AuthInfoName != nil && *o.k8sOptions.AuthInfoName != "" {
+		return *o.k8sOptions.AuthInfoName
+	}
+	rawConfig, err := o.k8sOptions.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return ""
+	}
+	contextName := rawConfig.CurrentContext
+	if o.k8sOptions.Context != nil && *o.k8sOptions.Context != "" {
+		contextName = *o.k8sOptions.Context
+	}
+	if c := rawConfig.Contexts[contextName]; c != nil {
+		return c.AuthInfo
+	}
+	return ""
+}
+
+// resolveContexts loads the config of each context of the tunnels.
+// If a tunnel has a context but no namespace, it sets the default namespace of the context.
+func resolveContexts(o rootCmdOptions, tunnels []tunnel.Tunnel) (map[string]*rest.Config, []tunnel.Tunnel, error) {
+	contextConfigs := make(map[string]*rest.Config)
+	contextNamespaces := make(map[string]string)
+	var resolved []tunnel.Tunnel
+	for _, t := range tunnels {
+		if t.Context == "" {
+			resolved = append(resolved, t)
+			continue
+		}
+		if _, ok := contextConfigs[t.Context]; !ok {
+			rawConfig, err := o.k8sOptions.ToRawKubeConfigLoader().RawConfig()
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not load the kubeconfig: %w", err)
+			}
+			overrides := configOverrides(o.k8sOptions)
+			overrides.CurrentContext = t.Context
+			cc := clientcmd.NewNonInteractiveClientConfig(rawConfig, t.Context, overrides, nil)
+			config, err := cc.ClientConfig()
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not load the config of context %s: %w", t.Context, err)
+			}
+			namespace, _, err := cc.Namespace()
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not determine the namespace of context %s: %w", t.Context, err)
+			}
+			contextConfigs[t.Context] = config
+			contextNamespaces[t.Context] = namespace
+		}
+		if t.Namespace == "" {
+			t.Namespace = contextNamespaces[t.Context]
+		}
+		resolved = append(resolved, t)
+	}
+	return contextConfigs, resolved, nil
+}
+
+// configOverrides returns the overrides of the global kubeconfig flags, such as --token or --server.
+// It is same as the overrides which genericclioptions.ConfigFlags applies to the current context.
+func configOverrides(f *genericclioptions.ConfigFlags) *clientcmd.ConfigOverrides {
+	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
 		}
-		tunnels = append(tunnels, tunnel.Tunnel{
-			LocalHost:  lh,
-			LocalPort:  l,
-			RemoteHost: s[1],
-			RemotePort: r,
-		})
-	}
-	return tunnels, nil
+	}
+	set(&overrides.AuthInfo.ClientCertificate, f.CertFile)
+	set(&overrides.AuthInfo.ClientKey, f.KeyFile)
+	set(&overrides.AuthInfo.Token, f.BearerToken)
+	set(&overrides.AuthInfo.Impersonate, f.Impersonate)
+	set(&overrides.AuthInfo.ImpersonateUID, f.ImpersonateUID)
+	if f.ImpersonateGroup != nil {
+		overrides.AuthInfo.ImpersonateGroups = *f.ImpersonateGroup
+	}
+	set(&overrides.AuthInfo.Username, f.Username)
+	set(&overrides.AuthInfo.Password, f.Password)
+	set(&overrides.ClusterInfo.Server, f.APIServer)
+	set(&overrides.ClusterInfo.TLSServerName, f.TLSServerName)
+	set(&overrides.ClusterInfo.CertificateAuthority, f.CAFile)
+	if f.Insecure != nil {
+		overrides.ClusterInfo.InsecureSkipTLSVerify = *f.Insecure
+	}
+	if f.DisableCompression != nil {
+		overrides.ClusterInfo.DisableCompression = *f.DisableCompression
+	}
+	set(&overrides.CurrentContext, f.Context)
+	set(&overrides.Context.Cluster, f.ClusterName)
+	set(&overrides.Context.AuthInfo, f.AuthInfoName)
+	set(&overrides.Context.Namespace, f.Namespace)
+	set(&overrides.Timeout, f.Timeout)
+	return overrides
 }
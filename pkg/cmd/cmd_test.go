@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/fakeapiserver"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// TestCmd_Run runs the whole command against the fake API server.
+func TestCmd_Run(t *testing.T) {
+	s := fakeapiserver.New()
+	defer s.Close()
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := s.WriteKubeconfig(kubeconfig); err != nil {
+		t.Fatalf("WriteKubeconfig error: %s", err)
+	}
+	upstream := fakeapiserver.StartEchoServer(t)
+	localPort := findFreePort(t)
+
+	cmd := Cmd{
+		ExternalForwarder: &externalforwarder.ExternalForwarder{
+			PortForwarder: &portforwarder.PortForwarder{},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	exitCode := make(chan int, 1)
+	go func() {
+		exitCode <- cmd.Run(ctx, []string{
+			"kubectl-external-forward",
+			"--kubeconfig", kubeconfig,
+			fmt.Sprintf("%d:%s:%d", localPort, upstream.IP, upstream.Port),
+		}, "v0.0.0")
+	}()
+
+	got, err := echoWithRetry(fmt.Sprintf("127.0.0.1:%d", localPort), "hello", 30*time.Second)
+	if err != nil {
+		t.Fatalf("could not connect to the tunnel: %s", err)
+	}
+	if got != "hello" {
+		t.Errorf("response wants hello but got %s", got)
+	}
+	pods := s.Pods()
+	if len(pods) != 1 {
+		t.Fatalf("pods want 1 but got %d", len(pods))
+	}
+	if pods[0].Labels["app.kubernetes.io/name"] != "kubectl-external-forward" {
+		t.Errorf("unexpected labels of the pod: %v", pods[0].Labels)
+	}
+
+	cancel()
+	if code := <-exitCode; code != 0 {
+		t.Errorf("exit code wants 0 but got %d", code)
+	}
+	if pods := s.Pods(); len(pods) != 0 {
+		t.Errorf("pods want none after exit but got %d", len(pods))
+	}
+}
+
+func TestResolveContexts(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	err := clientcmd.WriteToFile(clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			"staging": {Server: "https://staging.example.com"},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"staging": {Token: "staging-token"},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			"default": {Cluster: "staging", AuthInfo: "staging"},
+			"staging": {Cluster: "staging", AuthInfo: "staging", Namespace: "app"},
+		},
+		CurrentContext: "default",
+	}, kubeconfig)
+	if err != nil {
+		t.Fatalf("WriteToFile error: %s", err)
+	}
+	k8sOptions := genericclioptions.NewConfigFlags(false)
+	*k8sOptions.KubeConfig = kubeconfig
+	*k8sOptions.BearerToken = "override-token"
+	*k8sOptions.Insecure = true
+	contextConfigs, tunnels, err := resolveContexts(rootCmdOptions{k8sOptions: k8sOptions}, []tunnel.Tunnel{
+		{Context: "staging", RemoteHost: "db.staging", RemotePort: 5432},
+	})
+	if err != nil {
+		t.Fatalf("resolveContexts error: %s", err)
+	}
+	config := contextConfigs["staging"]
+	if config == nil {
+		t.Fatalf("contextConfigs wants staging but got %v", contextConfigs)
+	}
+	// the global flags are applied to the context of a tunnel
+	if diff := cmp.Diff("override-token", config.BearerToken); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if !config.Insecure {
+		t.Errorf("Insecure wants true")
+	}
+	if diff := cmp.Diff("app", tunnels[0].Namespace); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseEgressCIDRs(t *testing.T) {
+	got, err := parseEgressCIDRs([]string{"db.staging=10.0.0.1/24", "db.staging=10.1.0.0/16", "api.staging=192.168.0.1/32"})
+	if err != nil {
+		t.Fatalf("parseEgressCIDRs error: %s", err)
+	}
+	want := map[string][]string{
+		"db.staging":  {"10.0.0.0/24", "10.1.0.0/16"},
+		"api.staging": {"192.168.0.1/32"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	for _, value := range []string{"db.staging", "=10.0.0.0/8", "db.staging=10.0.0.1"} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := parseEgressCIDRs([]string{value}); err == nil {
+				t.Errorf("parseEgressCIDRs wants error but got nil")
+			}
+		})
+	}
+}
+
+func findFreePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// echoWithRetry sends the message to the address and returns the response.
+// It retries until the timeout because the tunnel may not be ready.
+func echoWithRetry(addr, message string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		got, err := fakeapiserver.Echo(addr, message)
+		if err == nil && got != message {
+			err = fmt.Errorf("response wants %q but got %q", message, got)
+		}
+		if err == nil {
+			return got, nil
+		}
+		if time.Now().After(deadline) {
+			return "", err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/spf13/cobra"
+)
+
+// debugStatPrefixes are the prefixes of stats shown in the summary.
+var debugStatPrefixes = []string{"cluster.cluster_", "listener.", "tcp."}
+
+func (cmd Cmd) newDebugCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "debug [flags] [LOCAL_HOST:]ADMIN_PORT",
+		Short:   "Show a summary of the admin interface of Envoy",
+		Long:    "Show a summary of the admin interface of Envoy forwarded by --admin-port.",
+		Example: `kubectl external-forward debug 19901`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runDebugCmd(c.Context(), args[0], c.OutOrStdout())
+		},
+	}
+}
+
+func runDebugCmd(ctx context.Context, arg string, w io.Writer) error {
+	addr := arg
+	if !strings.Contains(addr, ":") {
+		addr = net.JoinHostPort("127.0.0.1", addr)
+	}
+	c := envoy.AdminClient{BaseURL: "http://" + addr}
+	dump, err := c.ConfigDump(ctx)
+	if err != nil {
+		return err
+	}
+	clusters, err := c.Clusters(ctx)
+	if err != nil {
+		return err
+	}
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	return writeDebugSummary(w, dump, clusters, stats)
+}
+
+func writeDebugSummary(w io.Writer, dump *envoy.ConfigDump, clusters *envoy.Clusters, stats []envoy.Stat) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "LISTENERS")
+	for _, c := range dump.Configs {
+		for _, l := range c.StaticListeners {
+			_, _ = fmt.Fprintf(tw, "  %s\t%s\n", l.Listener.Name, l.Listener.Address)
+		}
+	}
+	types := make(map[string]string)
+	for _, c := range dump.Configs {
+		for _, cluster := range c.StaticClusters {
+			types[cluster.Cluster.Name] = cluster.Cluster.Type
+		}
+	}
+	_, _ = fmt.Fprintln(tw, "CLUSTERS")
+	for _, cs := range clusters.ClusterStatuses {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\n", cs.Name, types[cs.Name])
+		for _, h := range cs.HostStatuses {
+			_, _ = fmt.Fprintf(tw, "    %s\t%s\tcx_active=%d\tcx_total=%d\tcx_connect_fail=%d\n",
+				h.Address, h.Health(), h.Stat("cx_active"), h.Stat("cx_total"), h.Stat("cx_connect_fail"))
+		}
+	}
+	_, _ = fmt.Fprintln(tw, "STATS (non-zero)")
+	for _, s := range stats {
+		if s.Value == 0 || !hasAnyPrefix(s.Name, debugStatPrefixes) {
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "  %s\t%d\n", s.Name, s.Value)
+	}
+	return tw.Flush()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
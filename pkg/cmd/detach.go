@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/daemon"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// detachTimeout is the timeout until the tunnels of a daemon are ready.
+	detachTimeout = 3 * time.Minute
+	// detachPollInterval is the interval to poll the status of a starting daemon.
+	detachPollInterval = 200 * time.Millisecond
+	// detachLogTailLines is the number of lines of the log shown when a daemon failed to start.
+	detachLogTailLines = 20
+	// detachStopTimeout is the timeout until a daemon deletes the pods and exits on stop.
+	detachStopTimeout = 30 * time.Second
+)
+
+// detachArgs returns the arguments of the background process from the parsed flags.
+// It does not use os.Args, because the command may be run as a kubectl plugin or alias.
+func detachArgs(flags *pflag.FlagSet, args []string) []string {
+	var detachArgs []string
+	flags.Visit(func(f *pflag.Flag) {
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			for _, e := range v.GetSlice() {
+				detachArgs = append(detachArgs, fmt.Sprintf("--%s=%s", f.Name, e))
+			}
+			return
+		}
+		detachArgs = append(detachArgs, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+	return append(detachArgs, args...)
+}
+
+// detach starts the same command in background and waits until the tunnels are ready.
+func detach(ctx context.Context, args []string, stdout io.Writer) error {
+	id, err := daemon.NewID()
+	if err != nil {
+		return err
+	}
+	c, err := daemon.Start(id, args)
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- c.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, detachTimeout)
+	defer cancel()
+	client := daemon.NewClient(id)
+	for {
+		select {
+		case err := <-exited:
+			logs := tailLogFile(daemon.LogPath(id), detachLogTailLines)
+			_ = os.Remove(daemon.LogPath(id))
+			return fmt.Errorf("background process exited (%v):\n%s", err, logs)
+		case <-ctx.Done():
+			stopDaemon(c.Process, exited, detachStopTimeout)
+			return fmt.Errorf("tunnels are not ready, see %s: %w", daemon.LogPath(id), ctx.Err())
+		case <-time.After(detachPollInterval):
+		}
+		status, err := client.Status(ctx)
+		if err != nil {
+			klog.V(1).Infof("waiting for the control socket: %s", err)
+			continue
+		}
+		if status.Ready {
+			_, _ = fmt.Fprintf(stdout, "%s\n", id)
+			klog.Infof("started %s (pid %d), stop it by `kubectl external-forward stop %s`", id, status.PID, id)
+			return c.Process.Release()
+		}
+	}
+}
+
+// stopDaemon terminates the daemon so that it deletes the pods.
+// If the daemon does not exit within the timeout, it kills the daemon.
+func stopDaemon(p *os.Process, exited <-chan error, timeout time.Duration) {
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		// Windows does not support the signal
+		klog.V(1).Infof("could not terminate the background process: %s", err)
+		_ = p.Kill()
+		return
+	}
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		klog.Infof("background process did not exit in %s, killing it", timeout)
+		_ = p.Kill()
+	}
+}
+
+// tailLogFile returns the last n lines of the file.
+func tailLogFile(name string, n int) string {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runDaemon runs the tunnels with the control socket.
+// It is called in the background process.
+func (cmd Cmd) runDaemon(ctx context.Context, id string, args []string, eo externalforwarder.Option) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// stop gracefully in the same way as an interrupt, so that the connections are drained
+	interruptChan := make(chan struct{})
+	eo.Interrupt = interruptChan
+	stop := func() {
+		select {
+		case interruptChan <- struct{}{}:
+		case <-ctx.Done():
+		}
+	}
+	s := &daemon.Server{
+		ID:           id,
+		Args:         args,
+		ReadyOnStart: eo.Lazy != nil,
+		Stop:         stop,
+	}
+	for _, t := range eo.Tunnels {
+		s.Tunnels = append(s.Tunnels, net.JoinHostPort(t.LocalHost, strconv.Itoa(t.LocalPort)))
+	}
+	eo.Events = event.Multi(eo.Events, s)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(ctx)
+	}()
+	err := cmd.ExternalForwarder.Do(ctx, eo)
+	cancel()
+	if err := <-serveErr; err != nil {
+		klog.Info(err)
+	}
+	if err == nil {
+		// keep the log on error, which is shown by the parent process
+		_ = os.Remove(daemon.LogPath(id))
+	}
+	return err
+}
+
+func (cmd Cmd) newPsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ps",
+		Short: "List the tunnels running in background",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, _ []string) error {
+			statuses, err := daemon.List(c.Context())
+			if err != nil {
+				return err
+			}
+			return writeDaemonStatuses(c.OutOrStdout(), statuses, time.Now())
+		},
+	}
+}
+
+func writeDaemonStatuses(w io.Writer, statuses []daemon.Status, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tPID\tAGE\tREADY\tTUNNELS\tARGS")
+	for _, s := range statuses {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t%t\t%s\t%s\n",
+			s.ID, s.PID, now.Sub(s.StartedAt).Round(time.Second), s.Ready, strings.Join(s.Tunnels, ","), daemon.DisplayArgs(s.Args))
+	}
+	return tw.Flush()
+}
+
+func (cmd Cmd) newStopCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop ID...",
+		Short: "Stop the tunnels running in background",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			for _, id := range args {
+				if err := daemon.NewClient(id).Stop(c.Context()); err != nil {
+					return err
+				}
+				klog.Infof("stopping %s", id)
+			}
+			return nil
+		},
+	}
+}
+
+func (cmd Cmd) newLogsCmd() *cobra.Command {
+	var follow bool
+	c := &cobra.Command{
+		Use:   "logs [flags] ID",
+		Short: "Show the logs of the tunnels running in background",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return daemon.NewClient(args[0]).Logs(c.Context(), follow, c.OutOrStdout())
+		},
+	}
+	c.Flags().BoolVarP(&follow, "follow", "f", false, "Keep showing the logs")
+	return c
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+)
+
+func TestStopDaemon(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available")
+	}
+	start := func(t *testing.T, script string) (*os.Process, <-chan error) {
+		t.Helper()
+		c := exec.Command("sh", "-c", script)
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start error: %s", err)
+		}
+		exited := make(chan error, 1)
+		go func() {
+			exited <- c.Wait()
+		}()
+		// wait for the trap to be set
+		time.Sleep(100 * time.Millisecond)
+		return c.Process, exited
+	}
+
+	t.Run("Terminate", func(t *testing.T) {
+		p, exited := start(t, "trap 'exit 0' TERM; while :; do sleep 0.1; done")
+		stopDaemon(p, exited, 10*time.Second)
+		if err := p.Signal(syscall.Signal(0)); err == nil {
+			t.Errorf("process wants exited")
+		}
+	})
+
+	t.Run("Kill", func(t *testing.T) {
+		p, exited := start(t, "trap '' TERM; while :; do sleep 0.1; done")
+		startedAt := time.Now()
+		stopDaemon(p, exited, 200*time.Millisecond)
+		err := <-exited
+		if err == nil {
+			t.Errorf("Wait wants an error of the killed process")
+		}
+		if elapsed := time.Since(startedAt); elapsed < 200*time.Millisecond {
+			t.Errorf("process wants killed after the timeout but elapsed %s", elapsed)
+		}
+	})
+}
+
+func TestDetachArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.Bool("detach", false, "")
+	flags.String("context", "", "")
+	flags.Duration("drain-timeout", 0, "")
+	flags.StringSlice("as-group", nil, "")
+	flags.String("image", "envoy", "")
+	// an alias of kubectl may put the flags anywhere
+	err := flags.Parse([]string{"--context", "prod", "15432:db:5432", "--detach", "--drain-timeout=1m", "--as-group=a", "--as-group=b,c"})
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	got := detachArgs(flags, flags.Args())
+	want := []string{
+		"--as-group=a",
+		"--as-group=b",
+		"--as-group=c",
+		"--context=prod",
+		"--detach=true",
+		"--drain-timeout=1m0s",
+		"15432:db:5432",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	"sigs.k8s.io/yaml"
+)
+
+// profile represents a file which contains the tunnels, such as:
+//
+//	tunnels:
+//	  - context: prod-eu
+//	    namespace: tools
+//	    localPort: 15432
+//	    remoteHost: db
+//	    remotePort: 5432
+type profile struct {
+	// Defaults are applied to the tunnels which omit the connection options
+	Defaults profileConnectionOptions `json:"defaults,omitempty"`
+	Tunnels  []profileTunnel          `json:"tunnels"`
+}
+
+type profileConnectionOptions struct {
+	ConnectTimeout     string `json:"connectTimeout,omitempty"`
+	IdleTimeout        string `json:"idleTimeout,omitempty"`
+	MaxConnections     int    `json:"maxConnections,omitempty"`
+	MaxPendingRequests int    `json:"maxPendingRequests,omitempty"`
+	TCPKeepalive       bool   `json:"tcpKeepalive,omitempty"`
+}
+
+func (p profileConnectionOptions) toConnectionOptions() (tunnel.ConnectionOptions, error) {
+	o := tunnel.ConnectionOptions{
+		MaxConnections:     p.MaxConnections,
+		MaxPendingRequests: p.MaxPendingRequests,
+		TCPKeepalive:       p.TCPKeepalive,
+	}
+	if p.ConnectTimeout != "" {
+		d, err := time.ParseDuration(p.ConnectTimeout)
+		if err != nil {
+			return o, fmt.Errorf("invalid connectTimeout: %w", err)
+		}
+		o.ConnectTimeout = d
+	}
+	if p.IdleTimeout != "" {
+		d, err := time.ParseDuration(p.IdleTimeout)
+		if err != nil {
+			return o, fmt.Errorf("invalid idleTimeout: %w", err)
+		}
+		o.IdleTimeout = d
+	}
+	return o, nil
+}
+
+type profileTunnel struct {
+	Context    string `json:"context,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	LocalHost  string `json:"localHost,omitempty"`
+	LocalPort  int    `json:"localPort,omitempty"`
+	RemoteHost string `json:"remoteHost,omitempty"`
+	RemotePort int    `json:"remotePort,omitempty"`
+	// Endpoints can be set instead of RemoteHost and RemotePort
+	Endpoints        []profileEndpoint   `json:"endpoints,omitempty"`
+	LBPolicy         string              `json:"lbPolicy,omitempty"`
+	HealthCheck      *profileHealthCheck `json:"healthCheck,omitempty"`
+	OutlierDetection bool                `json:"outlierDetection,omitempty"`
+	profileConnectionOptions
+}
+
+type profileEndpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type profileHealthCheck struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+func loadProfile(name string) (*profile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the profile: %w", err)
+	}
+	var p profile
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, fmt.Errorf("could not parse the profile: %w", err)
+	}
+	return &p, nil
+}
+
+func (p profile) toTunnels() ([]tunnel.Tunnel, error) {
+	var tunnels []tunnel.Tunnel
+	for i, pt := range p.Tunnels {
+		t := tunnel.Tunnel{
+			Context:          pt.Context,
+			Namespace:        pt.Namespace,
+			LocalHost:        pt.LocalHost,
+			LocalPort:        pt.LocalPort,
+			RemoteHost:       pt.RemoteHost,
+			RemotePort:       pt.RemotePort,
+			LBPolicy:         pt.LBPolicy,
+			OutlierDetection: pt.OutlierDetection,
+		}
+		co, err := pt.profileConnectionOptions.toConnectionOptions()
+		if err != nil {
+			return nil, fmt.Errorf("tunnels[%d]: %w", i, err)
+		}
+		t.ConnectionOptions = co
+		if pt.HealthCheck != nil {
+			t.HealthCheck = &tunnel.HealthCheck{Type: pt.HealthCheck.Type, Path: pt.HealthCheck.Path}
+		}
+		if len(pt.Endpoints) > 0 {
+			if t.RemoteHost != "" || t.RemotePort != 0 {
+				return nil, fmt.Errorf("tunnels[%d]: either remoteHost/remotePort or endpoints can be set", i)
+			}
+			for _, e := range pt.Endpoints {
+				t.Endpoints = append(t.Endpoints, tunnel.Endpoint{Host: e.Host, Port: e.Port})
+			}
+			t.RemoteHost, t.RemotePort = t.Endpoints[0].Host, t.Endpoints[0].Port
+			if len(t.Endpoints) > 1 {
+				t.DiscoveryType = "STRICT_DNS"
+			}
+		}
+		if t.RemoteHost == "" || (t.RemotePort == 0 && !t.IsSRV()) {
+			return nil, fmt.Errorf("tunnels[%d]: remoteHost and remotePort are required", i)
+		}
+		if t.LocalPort == 0 {
+			if t.IsSRV() {
+				return nil, fmt.Errorf("tunnels[%d]: localPort is required for SRV record", i)
+			}
+			t.LocalPort = t.RemotePort
+		}
+		if err := validateTunnelOptions(&t); err != nil {
+			return nil, fmt.Errorf("tunnels[%d]: %w", i, err)
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels, nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/spf13/cobra"
+)
+
+func (cmd Cmd) newStdioCmd(o *rootCmdOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "stdio [flags] REMOTE_HOST:REMOTE_PORT",
+		Short:   "Forward stdin and stdout to the remote host",
+		Example: `ssh -o ProxyCommand='kubectl external-forward stdio %h:%p' bastion.internal`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runStdioCmd(c.Context(), *o, args[0], c.InOrStdin(), c.OutOrStdout())
+		},
+	}
+}
+
+func (cmd Cmd) runStdioCmd(ctx context.Context, o rootCmdOptions, arg string, stdin io.Reader, stdout io.Writer) error {
+	host, port, err := parseStdioArg(arg)
+	if err != nil {
+		return fmt.Errorf("invalid argument %s: %w", arg, err)
+	}
+	restConfig, err := o.k8sOptions.ToRESTConfig()
+	if err != nil {
+		return fmt.Errorf("could not load the config: %w", err)
+	}
+	namespace, _, err := o.k8sOptions.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return fmt.Errorf("could not determine the namespace: %w", err)
+	}
+	return cmd.ExternalForwarder.Stdio(ctx, externalforwarder.StdioOption{
+		Config:          restConfig,
+		Namespace:       namespace,
+		PodImage:        o.image,
+		RemoteHost:      host,
+		RemotePort:      port,
+		Stdin:           stdin,
+		Stdout:          stdout,
+		PolicyConfigMap: o.policyConfigMap,
+		Version:         o.version,
+		KubeconfigUser:  kubeconfigUser(o),
+	})
+}
+
+// parseStdioArg parses an argument in form of REMOTE_HOST:REMOTE_PORT or a name of SRV record.
+func parseStdioArg(arg string) (string, int, error) {
+	if strings.HasPrefix(arg, "_") {
+		return arg, 0, nil
+	}
+	host, portString, err := net.SplitHostPort(arg)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid remote port: %w", err)
+	}
+	return host, port, nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tui"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// tuiLogTailLines is the number of log lines shown after the dashboard is closed.
+	tuiLogTailLines = 20
+	// tuiErrorLogTailLines is the number of log lines shown on error, such as the report of the permissions.
+	tuiErrorLogTailLines = 200
+)
+
+// runTUI runs the tunnels with the dashboard on the terminal.
+// The logs are shown in the dashboard instead of stderr.
+func (cmd Cmd) runTUI(ctx context.Context, eo externalforwarder.Option) (err error) {
+	d := tui.New(eo.Tunnels, eo.Namespace)
+	eo.Events = event.Multi(eo.Events, d)
+	eo.Monitor = d
+	eo.PortForwarderOut = d
+	eo.PortForwarderErrOut = d
+	// the report of the permissions is hidden by the alternate screen if it is written to stderr
+	eo.ReportOut = d
+	klog.LogToStderr(false)
+	klog.SetOutput(d)
+	defer func() {
+		klog.LogToStderr(true)
+		n := tuiLogTailLines
+		if err != nil {
+			n = tuiErrorLogTailLines
+		}
+		for _, line := range d.Logs(n) {
+			_, _ = fmt.Fprintln(os.Stderr, line)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// quit gracefully in the same way as Ctrl-C, which is not a signal in raw mode
+	interruptChan := make(chan struct{})
+	eo.Interrupt = interruptChan
+	interrupt := func() {
+		select {
+		case interruptChan <- struct{}{}:
+		case <-ctx.Done():
+		}
+	}
+	uiErr := make(chan error, 1)
+	go func() {
+		uiErr <- d.Run(ctx, os.Stdin, os.Stdout, interrupt)
+	}()
+	err = cmd.ExternalForwarder.Do(ctx, eo)
+	cancel()
+	if err := <-uiErr; err != nil {
+		klog.Info(err)
+	}
+	return err
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+// parseTunnelArgs parses the arguments.
+// If local host is omitted, it leaves LocalHost empty.
+// If local port is omitted, it is same as the remote port.
+func parseTunnelArgs(args []string) ([]tunnel.Tunnel, error) {
+	var tunnels []tunnel.Tunnel
+	for _, arg := range args {
+		t, err := parseTunnelArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels, nil
+}
+
+// parseTunnelArg parses an argument in form of
+// [QUALIFIERS:][[LOCAL_HOST:]LOCAL_PORT:]REMOTE_HOST:REMOTE_PORT,
+// where QUALIFIERS is a comma separated list of KEY=VALUE.
+// REMOTE_HOST:REMOTE_PORT may be followed by comma separated additional hosts.
+// REMOTE_HOST may be svc/NAME[.NAMESPACE] to refer a Kubernetes Service.
+// REMOTE_HOST:REMOTE_PORT may be a name of SRV record, such as _postgres._tcp.db.internal.
+func parseTunnelArg(arg string) (tunnel.Tunnel, error) {
+	// the tunnel does not contain "=", hence the qualifiers end after the last "="
+	last := strings.LastIndex(arg, "=")
+	if last < 0 {
+		return parseTunnel(arg)
+	}
+	// a qualifier value may contain ":", such as an ARN of EKS cluster.
+	// find the first ":" which is followed by a valid tunnel
+	var firstErr error
+	for i := last; i < len(arg); i++ {
+		if arg[i] != ':' {
+			continue
+		}
+		t, err := parseTunnel(arg[i+1:])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := parseQualifiers(arg[:i], &t); err != nil {
+			return t, fmt.Errorf("invalid argument %s: %w", arg, err)
+		}
+		return t, nil
+	}
+	if firstErr != nil {
+		return tunnel.Tunnel{}, firstErr
+	}
+	return tunnel.Tunnel{}, fmt.Errorf("invalid argument %s", arg)
+}
+
+// parseTunnel parses an argument without the qualifiers.
+func parseTunnel(arg string) (tunnel.Tunnel, error) {
+	var t tunnel.Tunnel
+	hosts := strings.Split(arg, ",")
+	var endpoints []tunnel.Endpoint
+	for _, h := range hosts[1:] {
+		e, err := parseEndpoint(h)
+		if err != nil {
+			return t, fmt.Errorf("invalid argument %s: %w", arg, err)
+		}
+		endpoints = append(endpoints, e)
+	}
+	arg = hosts[0]
+	s := strings.Split(arg, ":")
+	if strings.HasPrefix(s[len(s)-1], "_") {
+		// SRV record has no remote port
+		if len(s) < 2 {
+			return t, fmt.Errorf("invalid argument %s: local port is required for SRV record", arg)
+		}
+		s = append(s, "0")
+	}
+	if len(s) > 4 || len(s) < 2 {
+		return t, fmt.Errorf("invalid argument %s", arg)
+	}
+	if len(s) == 4 {
+		if net.ParseIP(s[0]) == nil {
+			return t, fmt.Errorf("invalid local host: %s", s[0])
+		}
+		t.LocalHost = s[0]
+		s = s[1:]
+	}
+	if len(s) == 2 {
+		s = append([]string{s[1]}, s...)
+	}
+	l, err := strconv.Atoi(s[0])
+	if err != nil {
+		return t, fmt.Errorf("invalid local port: %w", err)
+	}
+	r, err := strconv.Atoi(s[2])
+	if err != nil {
+		return t, fmt.Errorf("invalid remote port: %w", err)
+	}
+	t.LocalPort = l
+	t.RemoteHost = s[1]
+	t.RemotePort = r
+	if len(endpoints) > 0 {
+		t.DiscoveryType = "STRICT_DNS"
+		t.Endpoints = append([]tunnel.Endpoint{{Host: t.RemoteHost, Port: t.RemotePort}}, endpoints...)
+	}
+	return t, nil
+}
+
+func parseEndpoint(s string) (tunnel.Endpoint, error) {
+	host, portString, err := net.SplitHostPort(s)
+	if err != nil {
+		return tunnel.Endpoint{}, fmt.Errorf("invalid endpoint %s: %w", s, err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return tunnel.Endpoint{}, fmt.Errorf("invalid port of endpoint %s: %w", s, err)
+	}
+	return tunnel.Endpoint{Host: host, Port: port}, nil
+}
+
+func parseQualifiers(s string, t *tunnel.Tunnel) error {
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 || p[1] == "" {
+			return fmt.Errorf("qualifier must be KEY=VALUE but was %s", kv)
+		}
+		switch p[0] {
+		case "ctx", "context":
+			t.Context = p[1]
+		case "ns", "namespace":
+			t.Namespace = p[1]
+		case "lb":
+			t.LBPolicy = p[1]
+		case "hc":
+			if t.HealthCheck == nil {
+				t.HealthCheck = &tunnel.HealthCheck{}
+			}
+			t.HealthCheck.Type = p[1]
+		case "hc-path":
+			if t.HealthCheck == nil {
+				t.HealthCheck = &tunnel.HealthCheck{Type: tunnel.HealthCheckHTTP}
+			}
+			t.HealthCheck.Path = p[1]
+		case "connect-timeout":
+			d, err := time.ParseDuration(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid connect-timeout: %w", err)
+			}
+			t.ConnectTimeout = d
+		case "idle-timeout":
+			d, err := time.ParseDuration(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid idle-timeout: %w", err)
+			}
+			t.IdleTimeout = d
+		case "max-conn":
+			n, err := strconv.Atoi(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid max-conn: %w", err)
+			}
+			t.MaxConnections = n
+		case "max-pending":
+			n, err := strconv.Atoi(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid max-pending: %w", err)
+			}
+			t.MaxPendingRequests = n
+		case "keepalive":
+			b, err := strconv.ParseBool(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid keepalive: %w", err)
+			}
+			t.TCPKeepalive = b
+		case "outlier":
+			b, err := strconv.ParseBool(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid outlier: %w", err)
+			}
+			t.OutlierDetection = b
+		default:
+			return fmt.Errorf("unknown qualifier %s", p[0])
+		}
+	}
+	return validateTunnelOptions(t)
+}
+
+// validateTunnelOptions validates and normalizes the options of the tunnel.
+func validateTunnelOptions(t *tunnel.Tunnel) error {
+	if t.LBPolicy != "" {
+		t.LBPolicy = strings.ToUpper(t.LBPolicy)
+		if t.LBPolicy == "RING_HASH" || t.LBPolicy == "MAGLEV" {
+			return fmt.Errorf("lb %s is not supported because a tunnel has no key to hash", strings.ToLower(t.LBPolicy))
+		}
+		if !contains(tunnel.LBPolicies, t.LBPolicy) {
+			return fmt.Errorf("lb must be one of %s", strings.Join(tunnel.LBPolicies, ", "))
+		}
+	}
+	if t.ConnectTimeout < 0 || t.IdleTimeout < 0 {
+		return fmt.Errorf("connect-timeout and idle-timeout must not be negative")
+	}
+	if t.MaxConnections < 0 || t.MaxPendingRequests < 0 {
+		return fmt.Errorf("max-conn and max-pending must not be negative")
+	}
+	if t.HealthCheck != nil {
+		switch t.HealthCheck.Type {
+		case tunnel.HealthCheckTCP:
+			if t.HealthCheck.Path != "" {
+				return fmt.Errorf("hc-path is available only for http health check")
+			}
+		case tunnel.HealthCheckHTTP:
+		default:
+			return fmt.Errorf("hc must be either tcp or http")
+		}
+	}
+	return nil
+}
+
+func contains(a []string, s string) bool {
+	for _, e := range a {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// assignLocalHosts sets the local host of each tunnel if it is omitted.
+// If loopbackAlias is true, it assigns a distinct loopback address for each remote host,
+// so that the same port can be used for different hosts.
+func assignLocalHosts(tunnels []tunnel.Tunnel, loopbackAlias bool) []tunnel.Tunnel {
+	aliases := make(map[string]string)
+	var assigned []tunnel.Tunnel
+	for _, t := range tunnels {
+		if t.LocalHost == "" {
+			t.LocalHost = "127.0.0.1"
+			if loopbackAlias {
+				if _, ok := aliases[t.RemoteHost]; !ok {
+					aliases[t.RemoteHost] = fmt.Sprintf("127.0.0.%d", len(aliases)+2)
+				}
+				t.LocalHost = aliases[t.RemoteHost]
+			}
+		}
+		assigned = append(assigned, t)
+	}
+	return assigned
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+func TestParseTunnelArgs(t *testing.T) {
+	t.Run("LocalHost", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{"0.0.0.0:15432:db.staging:5432"})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{LocalHost: "0.0.0.0", LocalPort: 15432, RemoteHost: "db.staging", RemotePort: 5432},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("OmitLocalPort", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{"db.staging:5432"})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{LocalPort: 5432, RemoteHost: "db.staging", RemotePort: 5432},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Qualifiers", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{
+			"ctx=prod-eu,ns=tools:15432:db:5432",
+			"ctx=staging:127.0.0.2:15433:db:5432",
+		})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{Context: "prod-eu", Namespace: "tools", LocalPort: 15432, RemoteHost: "db", RemotePort: 5432},
+			{Context: "staging", LocalHost: "127.0.0.2", LocalPort: 15433, RemoteHost: "db", RemotePort: 5432},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ContextWithColons", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{
+			"ctx=arn:aws:eks:us-east-1:123456789012:cluster/prod:15432:db:5432",
+			"ns=tools,ctx=arn:aws:eks:us-east-1:123456789012:cluster/prod:db:5432",
+			"ctx=arn:aws:eks:us-east-1:123456789012:cluster/prod:127.0.0.2:15433:db:5432,db2:5432",
+		})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		const arn = "arn:aws:eks:us-east-1:123456789012:cluster/prod"
+		want := []tunnel.Tunnel{
+			{Context: arn, LocalPort: 15432, RemoteHost: "db", RemotePort: 5432},
+			{Context: arn, Namespace: "tools", LocalPort: 5432, RemoteHost: "db", RemotePort: 5432},
+			{
+				Context: arn, LocalHost: "127.0.0.2", LocalPort: 15433, RemoteHost: "db", RemotePort: 5432,
+				DiscoveryType: "STRICT_DNS",
+				Endpoints:     []tunnel.Endpoint{{Host: "db", Port: 5432}, {Host: "db2", Port: 5432}},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("SRV", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{"15432:_postgres._tcp.db.internal"})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{LocalPort: 15432, RemoteHost: "_postgres._tcp.db.internal"},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("MultipleHosts", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{"lb=least_request,hc=http,hc-path=/healthz,outlier=true:15432:db-a:5432,db-b:5432"})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{
+				LocalPort:     15432,
+				RemoteHost:    "db-a",
+				RemotePort:    5432,
+				DiscoveryType: "STRICT_DNS",
+				Endpoints: []tunnel.Endpoint{
+					{Host: "db-a", Port: 5432},
+					{Host: "db-b", Port: 5432},
+				},
+				LBPolicy:         "LEAST_REQUEST",
+				HealthCheck:      &tunnel.HealthCheck{Type: "http", Path: "/healthz"},
+				OutlierDetection: true,
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ConnectionOptions", func(t *testing.T) {
+		got, err := parseTunnelArgs([]string{"connect-timeout=3s,idle-timeout=1h,max-conn=100,max-pending=10,keepalive=true:15432:db:5432"})
+		if err != nil {
+			t.Fatalf("parseTunnelArgs error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{
+				LocalPort:  15432,
+				RemoteHost: "db",
+				RemotePort: 5432,
+				ConnectionOptions: tunnel.ConnectionOptions{
+					ConnectTimeout:     3 * time.Second,
+					IdleTimeout:        time.Hour,
+					MaxConnections:     100,
+					MaxPendingRequests: 10,
+					TCPKeepalive:       true,
+				},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ConnectionOptionsRange", func(t *testing.T) {
+		testCases := map[string]struct {
+			arg     string
+			wantErr string
+		}{
+			"ZeroTimeout":         {arg: "connect-timeout=0s,idle-timeout=0s:15432:db:5432"},
+			"ZeroMaxConn":         {arg: "max-conn=0,max-pending=0:15432:db:5432"},
+			"NegativeTimeout":     {arg: "connect-timeout=-1s:15432:db:5432", wantErr: "must not be negative"},
+			"NegativeIdleTimeout": {arg: "idle-timeout=-1s:15432:db:5432", wantErr: "must not be negative"},
+			"NegativeMaxConn":     {arg: "max-conn=-1:15432:db:5432", wantErr: "must not be negative"},
+			"NegativeMaxPending":  {arg: "max-pending=-1:15432:db:5432", wantErr: "must not be negative"},
+		}
+		for name, testCase := range testCases {
+			t.Run(name, func(t *testing.T) {
+				_, err := parseTunnelArgs([]string{testCase.arg})
+				if testCase.wantErr == "" {
+					if err != nil {
+						t.Errorf("parseTunnelArgs error: %s", err)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Errorf("error wants %s but got %v", testCase.wantErr, err)
+				}
+			})
+		}
+	})
+
+	t.Run("InvalidLBPolicy", func(t *testing.T) {
+		_, err := parseTunnelArgs([]string{"lb=foo:15432:db:5432"})
+		if err == nil {
+			t.Errorf("parseTunnelArgs wants error but was nil")
+		}
+	})
+
+	t.Run("HashLBPolicy", func(t *testing.T) {
+		_, err := parseTunnelArgs([]string{"lb=ring_hash:15432:db-a:5432,db-b:5432"})
+		if err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("error wants not supported but got %v", err)
+		}
+	})
+
+	t.Run("UnknownQualifier", func(t *testing.T) {
+		_, err := parseTunnelArgs([]string{"foo=bar:15432:db:5432"})
+		if err == nil {
+			t.Errorf("parseTunnelArgs wants error but was nil")
+		}
+	})
+}
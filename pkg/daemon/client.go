@@ -0,0 +1,128 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Client talks to a daemon via the control socket.
+type Client struct {
+	ID     string
+	client *http.Client
+}
+
+func NewClient(id string) *Client {
+	path := SocketPath(id)
+	return &Client{
+		ID: id,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the status of the daemon.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &status, nil
+}
+
+// Stop requests the daemon to delete the pods and exit.
+func (c *Client) Stop(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodPost, "/stop")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Logs writes the logs of the daemon.
+// If follow is true, it keeps writing the logs until the context is canceled or the daemon exits.
+func (c *Client) Logs(ctx context.Context, follow bool, w io.Writer) error {
+	path := "/logs"
+	if follow {
+		path += "?follow=1"
+	}
+	resp, err := c.do(ctx, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("could not read the logs: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://daemon"+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", c.ID, err)
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s: %s", c.ID, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// List returns the status of the running daemons.
+// It removes the socket and log of a daemon which is not running.
+func List(ctx context.Context) ([]Status, error) {
+	if err := checkDir(Dir()); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sockets, err := filepath.Glob(filepath.Join(Dir(), "*.sock"))
+	if err != nil {
+		return nil, fmt.Errorf("could not find the control sockets: %w", err)
+	}
+	var statuses []Status
+	for _, socket := range sockets {
+		id := strings.TrimSuffix(filepath.Base(socket), ".sock")
+		status, err := NewClient(id).Status(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// the daemon has exited without cleanup
+			_ = os.Remove(socket)
+			_ = os.Remove(LogPath(id))
+			continue
+		}
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartedAt.Before(statuses[j].StartedAt)
+	})
+	return statuses, nil
+}
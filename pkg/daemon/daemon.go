@@ -0,0 +1,106 @@
+// Package daemon provides the background mode and its control socket.
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IDEnv is the environment variable which has the ID of the daemon.
+// It is set to the child process.
+const IDEnv = "KUBECTL_EXTERNAL_FORWARD_DAEMON_ID"
+
+// Status represents the status of a daemon.
+type Status struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	Args      []string  `json:"args"`
+	StartedAt time.Time `json:"started_at"`
+	// Tunnels is the local addresses of the tunnels
+	Tunnels []string `json:"tunnels"`
+	// Ready is true if all tunnels are ready
+	Ready bool `json:"ready"`
+}
+
+// Dir returns the directory of the control sockets and logs.
+// It is $XDG_RUNTIME_DIR/kubectl-external-forward if set,
+// otherwise a directory of the user in the temporary directory.
+func Dir() string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return filepath.Join(d, "kubectl-external-forward")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kubectl-external-forward-%d", os.Getuid()))
+}
+
+// MakeDir creates the directory if it does not exist.
+// It returns an error if the directory is not private to the current user,
+// because another user may have created it in the temporary directory.
+func MakeDir() error {
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return fmt.Errorf("could not create the directory: %w", err)
+	}
+	return checkDir(Dir())
+}
+
+// SocketPath returns the path of the control socket of the daemon.
+func SocketPath(id string) string {
+	return filepath.Join(Dir(), id+".sock")
+}
+
+// LogPath returns the path of the log file of the daemon.
+func LogPath(id string) string {
+	return filepath.Join(Dir(), id+".log")
+}
+
+// NewID returns a random ID of a daemon.
+func NewID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate an ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Start starts the current executable with the arguments in background.
+// The child process receives the ID in IDEnv and writes the output into the log file.
+func Start(id string, args []string) (*exec.Cmd, error) {
+	if err := MakeDir(); err != nil {
+		return nil, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the executable: %w", err)
+	}
+	logFile, err := os.OpenFile(LogPath(id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the log file: %w", err)
+	}
+	defer logFile.Close()
+	c := exec.Command(executable, args...)
+	c.Env = append(os.Environ(), IDEnv+"="+id)
+	c.Stdout = logFile
+	c.Stderr = logFile
+	c.SysProcAttr = sysProcAttr()
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("could not start the process: %w", err)
+	}
+	return c, nil
+}
+
+// DisplayArgs returns the arguments without the flag of background mode.
+func DisplayArgs(args []string) string {
+	var display []string
+	for _, arg := range args {
+		if arg == "--detach" || strings.HasPrefix(arg, "--detach=") {
+			continue
+		}
+		display = append(display, arg)
+	}
+	return strings.Join(display, " ")
+}
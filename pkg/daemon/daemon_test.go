@@ -0,0 +1,163 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+)
+
+func TestServer(t *testing.T) {
+	setRuntimeDir(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		t.Fatalf("MkdirAll error: %s", err)
+	}
+	if err := os.WriteFile(LogPath("abcd1234"), []byte("hello\n"), 0600); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	stopped := make(chan struct{})
+	s := &Server{
+		ID:      "abcd1234",
+		Args:    []string{"--detach", "15432:db:5432"},
+		Tunnels: []string{"127.0.0.1:15432"},
+		Stop:    func() { close(stopped) },
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(ctx)
+	}()
+	c := NewClient("abcd1234")
+	status := waitForStatus(t, c)
+	if status.Ready {
+		t.Errorf("Ready wants false before the tunnels are ready")
+	}
+
+	event.Emit(s, event.Event{Type: event.TunnelReady, LocalAddr: "127.0.0.1:15432"})
+	status = waitForStatus(t, c)
+	want := Status{
+		ID:        "abcd1234",
+		PID:       os.Getpid(),
+		Args:      []string{"--detach", "15432:db:5432"},
+		StartedAt: status.StartedAt,
+		Tunnels:   []string{"127.0.0.1:15432"},
+		Ready:     true,
+	}
+	if diff := cmp.Diff(&want, status); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("List", func(t *testing.T) {
+		statuses, err := List(ctx)
+		if err != nil {
+			t.Fatalf("List error: %s", err)
+		}
+		if len(statuses) != 1 || statuses[0].ID != "abcd1234" {
+			t.Errorf("List wants abcd1234 but got %+v", statuses)
+		}
+	})
+
+	t.Run("Logs", func(t *testing.T) {
+		var b strings.Builder
+		if err := c.Logs(ctx, false, &b); err != nil {
+			t.Fatalf("Logs error: %s", err)
+		}
+		if diff := cmp.Diff("hello\n", b.String()); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop error: %s", err)
+	}
+	<-stopped
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Errorf("Serve error: %s", err)
+	}
+	if _, err := os.Stat(SocketPath("abcd1234")); !os.IsNotExist(err) {
+		t.Errorf("socket wants removed but got %v", err)
+	}
+}
+
+func TestDisplayArgs(t *testing.T) {
+	got := DisplayArgs([]string{"--detach", "--lazy", "15432:db:5432", "--detach=true"})
+	if diff := cmp.Diff("--lazy 15432:db:5432", got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func setRuntimeDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "daemon")
+	if err != nil {
+		t.Fatalf("MkdirTemp error: %s", err)
+	}
+	original, ok := os.LookupEnv("XDG_RUNTIME_DIR")
+	_ = os.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv("XDG_RUNTIME_DIR", original)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+		_ = os.RemoveAll(dir)
+	})
+}
+
+func waitForStatus(t *testing.T, c *Client) *Status {
+	for i := 0; i < 50; i++ {
+		status, err := c.Status(context.TODO())
+		if err == nil {
+			return status
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("control socket is not available")
+	return nil
+}
+
+func TestMakeDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no mode of a file")
+	}
+	t.Run("Create", func(t *testing.T) {
+		setRuntimeDir(t)
+		if err := MakeDir(); err != nil {
+			t.Fatalf("MakeDir error: %s", err)
+		}
+		if err := MakeDir(); err != nil {
+			t.Errorf("MakeDir wants idempotent but got %s", err)
+		}
+	})
+	t.Run("WorldWritable", func(t *testing.T) {
+		setRuntimeDir(t)
+		if err := os.Mkdir(Dir(), 0700); err != nil {
+			t.Fatalf("Mkdir error: %s", err)
+		}
+		if err := os.Chmod(Dir(), 0777); err != nil {
+			t.Fatalf("Chmod error: %s", err)
+		}
+		if err := MakeDir(); err == nil {
+			t.Errorf("MakeDir wants an error")
+		}
+	})
+	t.Run("SymbolicLink", func(t *testing.T) {
+		setRuntimeDir(t)
+		target := t.TempDir()
+		if err := os.Chmod(target, 0700); err != nil {
+			t.Fatalf("Chmod error: %s", err)
+		}
+		if err := os.Symlink(target, Dir()); err != nil {
+			t.Fatalf("Symlink error: %s", err)
+		}
+		if err := MakeDir(); err == nil {
+			t.Errorf("MakeDir wants an error")
+		}
+	})
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkDir verifies that the directory is owned by the current user and has the mode 0700.
+// It does not follow a symbolic link.
+func checkDir(name string) error {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("could not stat the directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", name)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not the current user", name, st.Uid)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s has the mode %s, want 0700", name, fi.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkDir verifies that the directory is not a symbolic link.
+// Windows has no owner and mode of a file.
+func checkDir(name string) error {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("could not stat the directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", name)
+	}
+	return nil
+}
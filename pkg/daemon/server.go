@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+)
+
+// logsPollInterval is the interval to read the log file on follow.
+const logsPollInterval = 500 * time.Millisecond
+
+// Server serves the status and operations of the daemon on the control socket.
+// It receives the events to determine the readiness of the tunnels.
+type Server struct {
+	ID   string
+	Args []string
+	// Tunnels is the local addresses of the tunnels
+	Tunnels []string
+	// If true, the tunnels are ready when the server starts
+	ReadyOnStart bool
+	// Stop is called on the stop request
+	Stop func()
+
+	mu        sync.Mutex
+	startedAt time.Time
+	ready     map[string]bool
+}
+
+// Emit implements event.Emitter.
+func (s *Server) Emit(e event.Event) {
+	if e.Type != event.TunnelReady {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready == nil {
+		s.ready = make(map[string]bool)
+	}
+	s.ready[e.LocalAddr] = true
+}
+
+// Status returns the current status.
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// compare the number because the local address of a tunnel may be a hostname
+	ready := s.ReadyOnStart || len(s.ready) >= len(s.Tunnels)
+	tunnels := append([]string{}, s.Tunnels...)
+	sort.Strings(tunnels)
+	return Status{
+		ID:        s.ID,
+		PID:       os.Getpid(),
+		Args:      s.Args,
+		StartedAt: s.startedAt,
+		Tunnels:   tunnels,
+		Ready:     ready,
+	}
+}
+
+// Serve listens on the control socket until the context is canceled.
+// It removes the socket on return.
+func (s *Server) Serve(ctx context.Context) error {
+	s.mu.Lock()
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+	if err := MakeDir(); err != nil {
+		return err
+	}
+	path := SocketPath(s.ID)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+	defer os.Remove(path)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/stop", s.handleStop)
+	mux.HandleFunc("/logs", s.handleLogs)
+	hs := http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = hs.Close()
+	}()
+	if err := hs.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not serve the control socket: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Status())
+}
+
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	if s.Stop != nil {
+		s.Stop()
+	}
+}
+
+// handleLogs writes the log file.
+// If follow is set, it keeps writing the appended logs until the client disconnects.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	f, err := os.Open(LogPath(s.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("content-type", "text/plain")
+	if _, err := io.Copy(w, f); err != nil {
+		return
+	}
+	if r.URL.Query().Get("follow") == "" {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(logsPollInterval):
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import "syscall"
+
+// sysProcAttr detaches the child process from the terminal.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
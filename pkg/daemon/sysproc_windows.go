@@ -0,0 +1,8 @@
+package daemon
+
+import "syscall"
+
+// sysProcAttr returns nil because Windows has no session of terminal.
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,19 @@
+package di
+
+import (
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// NewClientsetFunc provides the factory of Kubernetes client.
+func NewClientsetFunc() externalforwarder.NewClientsetFunc {
+	return func(config *rest.Config) (kubernetes.Interface, error) {
+		return kubernetes.NewForConfig(config)
+	}
+}
+
+// WhoAmIFunc provides the lookup of the user of the cluster.
+func WhoAmIFunc() externalforwarder.WhoAmIFunc {
+	return externalforwarder.WhoAmI
+}
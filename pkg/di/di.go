@@ -17,6 +17,8 @@ func NewCmd() cmd.Interface {
 		cmd.Set,
 		portforwarder.Set,
 		externalforwarder.Set,
+		NewClientsetFunc,
+		WhoAmIFunc,
 	)
 	return nil
 }
@@ -15,8 +15,12 @@ import (
 
 func NewCmd() cmd.Interface {
 	portForwarder := &portforwarder.PortForwarder{}
+	newClientsetFunc := NewClientsetFunc()
+	whoAmIFunc := WhoAmIFunc()
 	externalForwarder := &externalforwarder.ExternalForwarder{
 		PortForwarder: portForwarder,
+		NewClientset:  newClientsetFunc,
+		WhoAmI:        whoAmIFunc,
 	}
 	cmdCmd := &cmd.Cmd{
 		ExternalForwarder: externalForwarder,
@@ -0,0 +1,104 @@
+// Package dnsserver provides a tiny DNS server which answers the tunnel hostnames.
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"k8s.io/klog/v2"
+)
+
+// Server answers A queries of the hostnames.
+// It responds NXDOMAIN for any other names.
+type Server struct {
+	Addr string
+	// Records maps a hostname to an IPv4 address
+	Records map[string]net.IP
+}
+
+// Run listens on the UDP address and serves until the context is done.
+func (s Server) Run(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", s.Addr, err)
+	}
+	klog.Infof("DNS server listening on %s", conn.LocalAddr())
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not read a packet: %w", err)
+		}
+		resp, err := s.handle(buf[:n])
+		if err != nil {
+			klog.V(1).Infof("DNS server: %s", err)
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			klog.V(1).Infof("DNS server: could not write a packet: %s", err)
+		}
+	}
+}
+
+func (s Server) handle(req []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, fmt.Errorf("invalid question: %w", err)
+	}
+
+	ip := s.lookup(q.Name.String())
+	rh := dnsmessage.Header{
+		ID:               h.ID,
+		Response:         true,
+		Authoritative:    true,
+		RecursionDesired: h.RecursionDesired,
+	}
+	if ip == nil {
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, rh)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if ip != nil && q.Type == dnsmessage.TypeA {
+		if err := b.StartAnswers(); err != nil {
+			return nil, err
+		}
+		var a dnsmessage.AResource
+		copy(a.A[:], ip.To4())
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 5}
+		if err := b.AResource(rh, a); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func (s Server) lookup(name string) net.IP {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for hostname, ip := range s.Records {
+		if strings.ToLower(hostname) == name {
+			return ip
+		}
+	}
+	return nil
+}
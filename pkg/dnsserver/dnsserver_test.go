@@ -0,0 +1,68 @@
+package dnsserver
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestServer_handle(t *testing.T) {
+	s := Server{
+		Records: map[string]net.IP{
+			"db.staging": net.ParseIP("127.0.0.2"),
+		},
+	}
+
+	t.Run("Found", func(t *testing.T) {
+		resp := query(t, s, "db.staging.")
+		if resp.Header.RCode != dnsmessage.RCodeSuccess {
+			t.Fatalf("RCode wants success but was %s", resp.Header.RCode)
+		}
+		if len(resp.Answers) != 1 {
+			t.Fatalf("len(Answers) wants 1 but was %d", len(resp.Answers))
+		}
+		a, ok := resp.Answers[0].Body.(*dnsmessage.AResource)
+		if !ok {
+			t.Fatalf("answer wants A but was %T", resp.Answers[0].Body)
+		}
+		if got := net.IP(a.A[:]).String(); got != "127.0.0.2" {
+			t.Errorf("A wants 127.0.0.2 but was %s", got)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		resp := query(t, s, "www.example.com.")
+		if resp.Header.RCode != dnsmessage.RCodeNameError {
+			t.Fatalf("RCode wants NXDOMAIN but was %s", resp.Header.RCode)
+		}
+		if len(resp.Answers) != 0 {
+			t.Errorf("len(Answers) wants 0 but was %d", len(resp.Answers))
+		}
+	})
+}
+
+func query(t *testing.T, s Server, name string) dnsmessage.Message {
+	req := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 1, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+	}
+	b, err := req.Pack()
+	if err != nil {
+		t.Fatalf("could not pack the request: %s", err)
+	}
+	respBytes, err := s.handle(b)
+	if err != nil {
+		t.Fatalf("handle error: %s", err)
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(respBytes); err != nil {
+		t.Fatalf("could not unpack the response: %s", err)
+	}
+	if resp.Header.ID != 1 {
+		t.Errorf("ID wants 1 but was %d", resp.Header.ID)
+	}
+	return resp
+}
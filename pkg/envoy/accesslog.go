@@ -0,0 +1,73 @@
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLog represents an access log of a connection emitted by the tcp_proxy filter.
+type AccessLog struct {
+	// TunnelIndex is the index of tunnel in the config
+	TunnelIndex int           `json:"tunnel"`
+	StartTime   string        `json:"start_time"`
+	Duration    time.Duration `json:"-"`
+	// BytesReceived is the bytes received from the client
+	BytesReceived int64 `json:"bytes_received"`
+	// BytesSent is the bytes sent to the client
+	BytesSent     int64  `json:"bytes_sent"`
+	UpstreamHost  string `json:"upstream_host"`
+	ResponseFlags string `json:"response_flags"`
+}
+
+// ParseAccessLog parses a line of the access log.
+// It returns false if the line is not an access log.
+func ParseAccessLog(line string) (*AccessLog, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	// Envoy may emit a value as either number or string
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		return nil, false
+	}
+	if _, ok := m["tunnel"]; !ok {
+		return nil, false
+	}
+	index, err := jsonInt(m["tunnel"])
+	if err != nil {
+		return nil, false
+	}
+	duration, _ := jsonInt(m["duration"])
+	bytesReceived, _ := jsonInt(m["bytes_received"])
+	bytesSent, _ := jsonInt(m["bytes_sent"])
+	return &AccessLog{
+		TunnelIndex:   int(index),
+		StartTime:     jsonString(m["start_time"]),
+		Duration:      time.Duration(duration) * time.Millisecond,
+		BytesReceived: bytesReceived,
+		BytesSent:     bytesSent,
+		UpstreamHost:  jsonString(m["upstream_host"]),
+		ResponseFlags: jsonString(m["response_flags"]),
+	}, true
+}
+
+func jsonInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case float64:
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("unexpected type %T", v)
+}
+
+func jsonString(v interface{}) string {
+	s, ok := v.(string)
+	if !ok || s == "-" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package envoy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAccessLog(t *testing.T) {
+	t.Run("Typed", func(t *testing.T) {
+		line := `{"tunnel":"1","start_time":"2023-02-20T01:02:03.456Z","duration":1500,"bytes_received":123,"bytes_sent":4567,"upstream_host":"10.0.0.1:5432","response_flags":"-"}`
+		got, ok := ParseAccessLog(line)
+		if !ok {
+			t.Fatalf("ParseAccessLog returned false")
+		}
+		want := &AccessLog{
+			TunnelIndex:   1,
+			StartTime:     "2023-02-20T01:02:03.456Z",
+			Duration:      1500 * time.Millisecond,
+			BytesReceived: 123,
+			BytesSent:     4567,
+			UpstreamHost:  "10.0.0.1:5432",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("String", func(t *testing.T) {
+		line := `{"tunnel":"0","start_time":"2023-02-20T01:02:03.456Z","duration":"3","bytes_received":"0","bytes_sent":"0","upstream_host":null,"response_flags":"UF"}`
+		got, ok := ParseAccessLog(line)
+		if !ok {
+			t.Fatalf("ParseAccessLog returned false")
+		}
+		want := &AccessLog{
+			StartTime:     "2023-02-20T01:02:03.456Z",
+			Duration:      3 * time.Millisecond,
+			ResponseFlags: "UF",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("NotAccessLog", func(t *testing.T) {
+		line := `[2023-02-20 01:02:03.456][1][info][main] [source/server/server.cc:923] starting main dispatch loop`
+		if _, ok := ParseAccessLog(line); ok {
+			t.Errorf("ParseAccessLog wants false but was true")
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package envoy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AdminClient is a client of the admin interface of Envoy.
+type AdminClient struct {
+	// BaseURL is the URL of the admin interface, such as http://127.0.0.1:9901
+	BaseURL string
+	Client  *http.Client
+}
+
+// Clusters represents the response of /clusters?format=json.
+type Clusters struct {
+	ClusterStatuses []ClusterStatus `json:"cluster_statuses"`
+}
+
+type ClusterStatus struct {
+	Name         string       `json:"name"`
+	HostStatuses []HostStatus `json:"host_statuses"`
+}
+
+type HostStatus struct {
+	Address      AdminAddress           `json:"address"`
+	Stats        []Stat                 `json:"stats"`
+	HealthStatus map[string]interface{} `json:"health_status"`
+}
+
+// Health returns the health status of the host, such as HEALTHY or failed_active_health_check.
+func (h HostStatus) Health() string {
+	var failures []string
+	for k, v := range h.HealthStatus {
+		if b, ok := v.(bool); ok && b {
+			failures = append(failures, k)
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return strings.Join(failures, ",")
+	}
+	if s, ok := h.HealthStatus["eds_health_status"].(string); ok {
+		return s
+	}
+	return "UNKNOWN"
+}
+
+// Stat returns the value of the stat, or 0 if not found.
+func (h HostStatus) Stat(name string) uint64 {
+	for _, s := range h.Stats {
+		if s.Name == name {
+			return uint64(s.Value)
+		}
+	}
+	return 0
+}
+
+type AdminAddress struct {
+	SocketAddress struct {
+		Address   string `json:"address"`
+		PortValue int    `json:"port_value"`
+	} `json:"socket_address"`
+}
+
+func (a AdminAddress) String() string {
+	return fmt.Sprintf("%s:%d", a.SocketAddress.Address, a.SocketAddress.PortValue)
+}
+
+// Stat represents a counter or gauge.
+type Stat struct {
+	Name  string    `json:"name"`
+	Value statValue `json:"value"`
+}
+
+// statValue accepts both a number and a string,
+// because Envoy renders uint64 as a string in some endpoints.
+type statValue uint64
+
+func (v *statValue) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid stat value %s: %w", b, err)
+	}
+	*v = statValue(n)
+	return nil
+}
+
+// ConfigDump represents the response of /config_dump.
+// It contains only the static listeners and clusters.
+type ConfigDump struct {
+	Configs []struct {
+		StaticListeners []struct {
+			Listener struct {
+				Name    string       `json:"name"`
+				Address AdminAddress `json:"address"`
+			} `json:"listener"`
+		} `json:"static_listeners"`
+		StaticClusters []struct {
+			Cluster struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"cluster"`
+		} `json:"static_clusters"`
+	} `json:"configs"`
+}
+
+// Clusters returns the status of the clusters.
+func (c AdminClient) Clusters(ctx context.Context) (*Clusters, error) {
+	var clusters Clusters
+	if err := c.get(ctx, "/clusters?format=json", &clusters); err != nil {
+		return nil, err
+	}
+	return &clusters, nil
+}
+
+// Stats returns the counters and gauges.
+// Histograms are not included.
+func (c AdminClient) Stats(ctx context.Context) ([]Stat, error) {
+	var resp struct {
+		Stats []json.RawMessage `json:"stats"`
+	}
+	if err := c.get(ctx, "/stats?format=json", &resp); err != nil {
+		return nil, err
+	}
+	var stats []Stat
+	for _, raw := range resp.Stats {
+		var s Stat
+		// an entry of histograms has no name and value
+		if err := json.Unmarshal(raw, &s); err != nil || s.Name == "" {
+			continue
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// ConfigDump returns the current configuration.
+func (c AdminClient) ConfigDump(ctx context.Context) (*ConfigDump, error) {
+	var dump ConfigDump
+	if err := c.get(ctx, "/config_dump", &dump); err != nil {
+		return nil, err
+	}
+	return &dump, nil
+}
+
+func (c AdminClient) get(ctx context.Context, path string, v interface{}) error {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("could not create a request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get %s: status %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package envoy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAdminClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clusters", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"cluster_statuses":[{"name":"cluster_0","host_statuses":[{
+"address":{"socket_address":{"address":"10.0.0.1","port_value":5432}},
+"stats":[{"name":"cx_connect_fail"},{"name":"cx_total","value":"3"},{"type":"GAUGE","name":"cx_active","value":"1"}],
+"health_status":{"eds_health_status":"HEALTHY"}},{
+"address":{"socket_address":{"address":"10.0.0.2","port_value":5432}},
+"health_status":{"eds_health_status":"HEALTHY","failed_active_health_check":true}}]}]}`))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"stats":[{"name":"cluster.cluster_0.upstream_cx_total","value":3},{"histograms":{}}]}`))
+	})
+	mux.HandleFunc("/config_dump", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"configs":[
+{"@type":"type.googleapis.com/envoy.admin.v3.ClustersConfigDump","static_clusters":[{"cluster":{"name":"cluster_0","type":"LOGICAL_DNS"}}]},
+{"@type":"type.googleapis.com/envoy.admin.v3.ListenersConfigDump","static_listeners":[{"listener":{"name":"listener_0","address":{"socket_address":{"address":"0.0.0.0","port_value":10080}}}}]}]}`))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	c := AdminClient{BaseURL: s.URL}
+	ctx := context.TODO()
+
+	t.Run("Clusters", func(t *testing.T) {
+		clusters, err := c.Clusters(ctx)
+		if err != nil {
+			t.Fatalf("Clusters error: %s", err)
+		}
+		if len(clusters.ClusterStatuses) != 1 || len(clusters.ClusterStatuses[0].HostStatuses) != 2 {
+			t.Fatalf("unexpected clusters: %+v", clusters)
+		}
+		h0, h1 := clusters.ClusterStatuses[0].HostStatuses[0], clusters.ClusterStatuses[0].HostStatuses[1]
+		got := []interface{}{h0.Address.String(), h0.Health(), h0.Stat("cx_total"), h0.Stat("cx_active"), h1.Health()}
+		want := []interface{}{"10.0.0.1:5432", "HEALTHY", uint64(3), uint64(1), "failed_active_health_check"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("Stats", func(t *testing.T) {
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			t.Fatalf("Stats error: %s", err)
+		}
+		want := []Stat{{Name: "cluster.cluster_0.upstream_cx_total", Value: 3}}
+		if diff := cmp.Diff(want, stats); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("ConfigDump", func(t *testing.T) {
+		dump, err := c.ConfigDump(ctx)
+		if err != nil {
+			t.Fatalf("ConfigDump error: %s", err)
+		}
+		if len(dump.Configs) != 2 {
+			t.Fatalf("len(Configs) wants 2 but got %d", len(dump.Configs))
+		}
+		if got := dump.Configs[0].StaticClusters[0].Cluster.Type; got != "LOGICAL_DNS" {
+			t.Errorf("cluster type wants LOGICAL_DNS but got %s", got)
+		}
+		if got := dump.Configs[1].StaticListeners[0].Listener.Address.String(); got != "0.0.0.0:10080" {
+			t.Errorf("listener address wants 0.0.0.0:10080 but got %s", got)
+		}
+	})
+}
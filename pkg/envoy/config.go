@@ -3,9 +3,12 @@ package envoy
 import (
 	"embed"
 	_ "embed"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/int128/kubectl-external-forward/pkg/tunnel"
 )
@@ -13,14 +16,40 @@ import (
 //go:embed template/*
 var configTemplateDir embed.FS
 
-var configTemplate = template.Must(template.ParseFS(configTemplateDir, "template/*"))
+var configTemplate = template.Must(template.New("").Funcs(template.FuncMap{
+	"duration": formatDuration,
+	"quote":    quote,
+}).ParseFS(configTemplateDir, "template/*"))
+
+// formatDuration returns the duration in form of Envoy, such as 1.5s.
+func formatDuration(d time.Duration) string {
+	// %g uses the exponent notation for a large or small value, which Envoy rejects
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// quote returns a double-quoted string of YAML.
+// A JSON string is a valid YAML string.
+func quote(s string) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Option represents an option of the Envoy config.
+type Option struct {
+	// If set, the admin interface listens on the port of localhost in the pod
+	AdminPort int
+}
 
 type configTemplateContext struct {
 	Tunnels []tunnel.Tunnel
+	Option  Option
 }
 
-func NewConfig(tunnels []tunnel.Tunnel) (string, error) {
-	c := configTemplateContext{Tunnels: tunnels}
+func NewConfig(tunnels []tunnel.Tunnel, o Option) (string, error) {
+	c := configTemplateContext{Tunnels: tunnels, Option: o}
 	var s strings.Builder
 	if err := configTemplate.ExecuteTemplate(&s, "envoy.yaml", c); err != nil {
 		return "", fmt.Errorf("template error: %w", err)
@@ -2,6 +2,7 @@ package envoy
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/int128/kubectl-external-forward/pkg/tunnel"
@@ -32,6 +33,19 @@ static_resources:
                 "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
                 stat_prefix: destination
                 cluster: cluster_0
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "0"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
   clusters:
     - name: cluster_0
       connect_timeout: 30s
@@ -47,7 +61,7 @@ static_resources:
                       address: www.example.com
                       port_value: 80
 `
-		got, err := NewConfig(tunnels)
+		got, err := NewConfig(tunnels, Option{})
 		if err != nil {
 			t.Fatalf("error NewConfig: %s", err)
 		}
@@ -86,6 +100,19 @@ static_resources:
                 "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
                 stat_prefix: destination
                 cluster: cluster_0
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "0"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
     - name: listener_1
       address:
         socket_address:
@@ -98,6 +125,19 @@ static_resources:
                 "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
                 stat_prefix: destination
                 cluster: cluster_1
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "1"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
   clusters:
     - name: cluster_0
       connect_timeout: 30s
@@ -126,7 +166,7 @@ static_resources:
                       address: db.staging
                       port_value: 5432
 `
-		got, err := NewConfig(tunnels)
+		got, err := NewConfig(tunnels, Option{})
 		if err != nil {
 			t.Fatalf("error NewConfig: %s", err)
 		}
@@ -134,4 +174,311 @@ static_resources:
 			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
 		}
 	})
+	t.Run("Endpoints", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{
+			{
+				LocalHost:     "127.0.0.1",
+				LocalPort:     15432,
+				RemoteHost:    "svc/db.staging",
+				RemotePort:    5432,
+				DiscoveryType: "STATIC",
+				Endpoints: []tunnel.Endpoint{
+					{Host: "10.0.0.1", Port: 5432},
+					{Host: "10.0.0.2", Port: 5432},
+				},
+			},
+		}
+		want := `---
+static_resources:
+  listeners:
+    - name: listener_0
+      address:
+        socket_address:
+          address: 0.0.0.0
+          port_value: 15432
+      filter_chains:
+        - filters:
+            - name: envoy.filters.network.tcp_proxy
+              typed_config:
+                "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+                stat_prefix: destination
+                cluster: cluster_0
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "0"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
+  clusters:
+    - name: cluster_0
+      connect_timeout: 30s
+      type: STATIC
+      dns_lookup_family: V4_ONLY
+      load_assignment:
+        cluster_name: cluster_0
+        endpoints:
+          - lb_endpoints:
+              - endpoint:
+                  address:
+                    socket_address:
+                      address: 10.0.0.1
+                      port_value: 5432
+              - endpoint:
+                  address:
+                    socket_address:
+                      address: 10.0.0.2
+                      port_value: 5432
+`
+		got, err := NewConfig(tunnels, Option{})
+		if err != nil {
+			t.Fatalf("error NewConfig: %s", err)
+		}
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+	t.Run("LoadBalancing", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{
+			{
+				LocalHost:     "127.0.0.1",
+				LocalPort:     15432,
+				RemoteHost:    "db-a",
+				RemotePort:    5432,
+				DiscoveryType: "STRICT_DNS",
+				Endpoints: []tunnel.Endpoint{
+					{Host: "db-a", Port: 5432},
+					{Host: "db-b", Port: 5432},
+				},
+				LBPolicy:         "LEAST_REQUEST",
+				HealthCheck:      &tunnel.HealthCheck{Type: tunnel.HealthCheckHTTP, Path: `/healthz?name="db": #1`},
+				OutlierDetection: true,
+			},
+		}
+		want := `---
+static_resources:
+  listeners:
+    - name: listener_0
+      address:
+        socket_address:
+          address: 0.0.0.0
+          port_value: 15432
+      filter_chains:
+        - filters:
+            - name: envoy.filters.network.tcp_proxy
+              typed_config:
+                "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+                stat_prefix: destination
+                cluster: cluster_0
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "0"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
+  clusters:
+    - name: cluster_0
+      connect_timeout: 30s
+      type: STRICT_DNS
+      dns_lookup_family: V4_ONLY
+      lb_policy: LEAST_REQUEST
+      load_assignment:
+        cluster_name: cluster_0
+        endpoints:
+          - lb_endpoints:
+              - endpoint:
+                  address:
+                    socket_address:
+                      address: db-a
+                      port_value: 5432
+              - endpoint:
+                  address:
+                    socket_address:
+                      address: db-b
+                      port_value: 5432
+      health_checks:
+        - timeout: 5s
+          interval: 10s
+          unhealthy_threshold: 3
+          healthy_threshold: 1
+          http_health_check:
+            path: "/healthz?name=\"db\": #1"
+      outlier_detection:
+        consecutive_5xx: 5
+        interval: 10s
+        base_ejection_time: 30s
+`
+		got, err := NewConfig(tunnels, Option{})
+		if err != nil {
+			t.Fatalf("error NewConfig: %s", err)
+		}
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+	t.Run("ConnectionOptions", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{
+			{
+				LocalHost:  "127.0.0.1",
+				LocalPort:  15432,
+				RemoteHost: "db.staging",
+				RemotePort: 5432,
+				ConnectionOptions: tunnel.ConnectionOptions{
+					ConnectTimeout:     1500 * time.Millisecond,
+					IdleTimeout:        10 * time.Minute,
+					MaxConnections:     100,
+					MaxPendingRequests: 10,
+					TCPKeepalive:       true,
+				},
+			},
+		}
+		want := `---
+static_resources:
+  listeners:
+    - name: listener_0
+      address:
+        socket_address:
+          address: 0.0.0.0
+          port_value: 15432
+      filter_chains:
+        - filters:
+            - name: envoy.filters.network.tcp_proxy
+              typed_config:
+                "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+                stat_prefix: destination
+                cluster: cluster_0
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "0"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
+                idle_timeout: 600s
+  clusters:
+    - name: cluster_0
+      connect_timeout: 1.5s
+      type: LOGICAL_DNS
+      dns_lookup_family: V4_ONLY
+      load_assignment:
+        cluster_name: cluster_0
+        endpoints:
+          - lb_endpoints:
+              - endpoint:
+                  address:
+                    socket_address:
+                      address: db.staging
+                      port_value: 5432
+      circuit_breakers:
+        thresholds:
+          - priority: DEFAULT
+            max_connections: 100
+            max_pending_requests: 10
+      upstream_connection_options:
+        tcp_keepalive: {}
+`
+		got, err := NewConfig(tunnels, Option{})
+		if err != nil {
+			t.Fatalf("error NewConfig: %s", err)
+		}
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{
+			{
+				LocalPort:  10080,
+				RemoteHost: "www.example.com",
+				RemotePort: 80,
+			},
+		}
+		want := `---
+static_resources:
+  listeners:
+    - name: listener_0
+      address:
+        socket_address:
+          address: 0.0.0.0
+          port_value: 10080
+      filter_chains:
+        - filters:
+            - name: envoy.filters.network.tcp_proxy
+              typed_config:
+                "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+                stat_prefix: destination
+                cluster: cluster_0
+                access_log:
+                  - name: envoy.access_loggers.stdout
+                    typed_config:
+                      "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+                      log_format:
+                        json_format:
+                          tunnel: "0"
+                          start_time: "%START_TIME%"
+                          duration: "%DURATION%"
+                          bytes_received: "%BYTES_RECEIVED%"
+                          bytes_sent: "%BYTES_SENT%"
+                          upstream_host: "%UPSTREAM_HOST%"
+                          response_flags: "%RESPONSE_FLAGS%"
+  clusters:
+    - name: cluster_0
+      connect_timeout: 30s
+      type: LOGICAL_DNS
+      dns_lookup_family: V4_ONLY
+      load_assignment:
+        cluster_name: cluster_0
+        endpoints:
+          - lb_endpoints:
+              - endpoint:
+                  address:
+                    socket_address:
+                      address: www.example.com
+                      port_value: 80
+admin:
+  address:
+    socket_address:
+      address: 127.0.0.1
+      port_value: 9901
+`
+		got, err := NewConfig(tunnels, Option{AdminPort: 9901})
+		if err != nil {
+			t.Fatalf("error NewConfig: %s", err)
+		}
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		1500 * time.Millisecond: "1.5s",
+		time.Microsecond:        "0.000001s",
+		1000000 * time.Second:   "1000000s",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%s) wants %s but got %s", d, want, got)
+		}
+	}
 }
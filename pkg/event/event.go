@@ -0,0 +1,105 @@
+// Package event provides machine-readable events of the tunnels.
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	PodCreated       Type = "pod_created"
+	PodRunning       Type = "pod_running"
+	TunnelReady      Type = "tunnel_ready"
+	ConnectionOpened Type = "connection_opened"
+	ConnectionClosed Type = "connection_closed"
+	ConnectionError  Type = "connection_error"
+	Reconnecting     Type = "reconnecting"
+	PodDeleted       Type = "pod_deleted"
+	Error            Type = "error"
+)
+
+// Event represents an event.
+// The fields are omitted if they are not relevant to the type.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Pod is the namespace and name of the pod, such as default/kubectl-external-forward-abcde
+	Pod string `json:"pod,omitempty"`
+	// Context is the kubeconfig context of the pod
+	Context string `json:"context,omitempty"`
+	// Node is the name of the node which the pod is running on
+	Node string `json:"node,omitempty"`
+	// LocalAddr is the address which the port forwarder listens on
+	LocalAddr string `json:"local_addr,omitempty"`
+	// Remote is the remote host and port of the tunnel
+	Remote string `json:"remote,omitempty"`
+	// Client is the address of the client of a connection
+	Client string `json:"client,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Emitter receives the events.
+// It must be safe for concurrent use.
+type Emitter interface {
+	Emit(e Event)
+}
+
+// Emit sends the event to the emitter.
+// It sets the current time if the event has no time.
+// It does nothing if the emitter is nil.
+func Emit(emitter Emitter, e Event) {
+	if emitter == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	emitter.Emit(e)
+}
+
+// JSONEmitter writes the events in newline-delimited JSON.
+type JSONEmitter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONEmitter(w io.Writer) *JSONEmitter {
+	return &JSONEmitter{w: w}
+}
+
+func (j *JSONEmitter) Emit(e Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, _ = j.w.Write(append(b, '\n'))
+}
+
+// Multi returns an emitter which sends the events to all the emitters.
+// It ignores nil emitters.
+func Multi(emitters ...Emitter) Emitter {
+	var m multiEmitter
+	for _, emitter := range emitters {
+		if emitter != nil {
+			m = append(m, emitter)
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+type multiEmitter []Emitter
+
+func (m multiEmitter) Emit(e Event) {
+	for _, emitter := range m {
+		emitter.Emit(e)
+	}
+}
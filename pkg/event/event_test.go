@@ -0,0 +1,24 @@
+package event
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONEmitter(t *testing.T) {
+	var b strings.Builder
+	e := NewJSONEmitter(&b)
+	ts := time.Date(2023, 2, 20, 10, 1, 2, 0, time.UTC)
+	Emit(e, Event{Type: PodCreated, Time: ts, Pod: "default/kubectl-external-forward-abcde"})
+	Emit(e, Event{Type: TunnelReady, Time: ts, Pod: "default/kubectl-external-forward-abcde", LocalAddr: "127.0.0.1:15432", Remote: "db:5432"})
+	Emit(nil, Event{Type: PodDeleted})
+	want := `{"type":"pod_created","time":"2023-02-20T10:01:02Z","pod":"default/kubectl-external-forward-abcde"}
+{"type":"tunnel_ready","time":"2023-02-20T10:01:02Z","pod":"default/kubectl-external-forward-abcde","local_addr":"127.0.0.1:15432","remote":"db:5432"}
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
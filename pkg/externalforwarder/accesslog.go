@@ -0,0 +1,72 @@
+package externalforwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"k8s.io/klog/v2"
+)
+
+// connectionLog represents a line of the local access log file.
+type connectionLog struct {
+	Pod    string `json:"pod"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	envoy.AccessLog
+	DurationMilliseconds int64 `json:"duration_ms"`
+}
+
+// handleAccessLog shows a connection event from the access log of Envoy.
+// If w is not nil, it also writes the event as a JSON line.
+func (f ExternalForwarder) handleAccessLog(g *podGroup, accessLog *envoy.AccessLog, w io.Writer) {
+	if accessLog.TunnelIndex < 0 || accessLog.TunnelIndex >= len(g.Tunnels) {
+		klog.V(1).Infof("access log of unknown tunnel %d", accessLog.TunnelIndex)
+		return
+	}
+	t := g.Tunnels[accessLog.TunnelIndex]
+	entry := connectionLog{
+		Pod:       fmt.Sprintf("%s/%s", g.pod.Namespace, g.pod.Name),
+		Local:     fmt.Sprintf("%s:%d", t.LocalHost, t.LocalPort),
+		Remote:    fmt.Sprintf("%s:%d", t.RemoteHost, t.RemotePort),
+		AccessLog: *accessLog,
+
+		DurationMilliseconds: accessLog.Duration.Milliseconds(),
+	}
+	upstream := accessLog.UpstreamHost
+	if upstream == "" {
+		upstream = "(none)"
+	}
+	msg := fmt.Sprintf("connection %s -> %s via %s: duration=%s, sent=%d bytes, received=%d bytes",
+		entry.Local, entry.Remote, upstream, accessLog.Duration, accessLog.BytesReceived, accessLog.BytesSent)
+	if accessLog.ResponseFlags != "" {
+		msg += fmt.Sprintf(", flags=%s", accessLog.ResponseFlags)
+	}
+	klog.Info(msg)
+
+	if w == nil {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		klog.Infof("could not encode the access log: %s", err)
+		return
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		klog.Infof("could not write the access log: %s", err)
+	}
+}
+
+// syncWriter serializes writes from the goroutines.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,43 @@
+package externalforwarder
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// envoyAdminPort is the port which the admin interface of Envoy listens on in the pod.
+const envoyAdminPort = tunnel.EnvoyAdminPort
+
+// envoyAdminBaseURL is the URL of the admin interface.
+// The host is not used because the client connects via port-forwarding.
+const envoyAdminBaseURL = "http://envoy"
+
+// envoyAdminHTTPClient returns a HTTP client which connects to the admin interface via port-forwarding.
+func (f ExternalForwarder) envoyAdminHTTPClient(config *rest.Config, pod *corev1.Pod) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return f.PortForwarder.Dial(ctx, portforwarder.StreamOption{
+					Config:              config,
+					TargetNamespace:     pod.Namespace,
+					TargetPodName:       pod.Name,
+					TargetContainerPort: envoyAdminPort,
+				})
+			},
+			IdleConnTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// envoyAdminClient returns a client of the admin interface via port-forwarding.
+func (f ExternalForwarder) envoyAdminClient(config *rest.Config, pod *corev1.Pod) envoy.AdminClient {
+	return envoy.AdminClient{BaseURL: envoyAdminBaseURL, Client: f.envoyAdminHTTPClient(config, pod)}
+}
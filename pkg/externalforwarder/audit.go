@@ -0,0 +1,186 @@
+package externalforwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+const (
+	versionLabelKey           = "app.kubernetes.io/version"
+	userAnnotationKey         = "kubectl-external-forward/user"
+	hostnameAnnotationKey     = "kubectl-external-forward/hostname"
+	startedAtAnnotationKey    = "kubectl-external-forward/started-at"
+	destinationsAnnotationKey = "kubectl-external-forward/destinations"
+
+	eventSourceComponent = "kubectl-external-forward"
+	// podEventTimeout is the timeout to create an event on the pod
+	podEventTimeout = 5 * time.Second
+)
+
+// auditInfo represents who opened the tunnels and where.
+type auditInfo struct {
+	Version   string
+	User      string
+	Hostname  string
+	StartedAt time.Time
+}
+
+// newAuditInfo returns the audit information of the cluster.
+// It determines the user by whoAmI, or falls back to the user of kubeconfig.
+func newAuditInfo(ctx context.Context, c kubernetes.Interface, whoAmI WhoAmIFunc, version, kubeconfigUser string) auditInfo {
+	a := auditInfo{Version: version, User: kubeconfigUser, StartedAt: time.Now()}
+	if user, err := whoAmI(ctx, c); err != nil {
+		klog.V(1).Infof("could not determine the user: %s", err)
+	} else {
+		a.User = user
+	}
+	if hostname, err := os.Hostname(); err != nil {
+		klog.V(1).Infof("could not determine the hostname: %s", err)
+	} else {
+		a.Hostname = hostname
+	}
+	return a
+}
+
+// selfSubjectReviewVersions are the versions of SelfSubjectReview in order of preference.
+// v1 is available since Kubernetes 1.28, v1beta1 since 1.27 and v1alpha1 since 1.26 (if enabled).
+var selfSubjectReviewVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+// WhoAmIFunc determines the user of the cluster.
+type WhoAmIFunc func(ctx context.Context, c kubernetes.Interface) (string, error)
+
+// WhoAmI returns the user by the first available version of SelfSubjectReview.
+// It sends the requests by the REST client, because the client set does not have v1beta1 and v1.
+func WhoAmI(ctx context.Context, c kubernetes.Interface) (string, error) {
+	rc, ok := c.AuthenticationV1().RESTClient().(*rest.RESTClient)
+	if !ok || rc == nil {
+		return "", fmt.Errorf("the client set has no REST client")
+	}
+	var lastErr error
+	for _, version := range selfSubjectReviewVersions {
+		user, err := createSelfSubjectReview(ctx, rc, version)
+		if err == nil {
+			return user, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return "", err
+		}
+		klog.V(1).Infof("SelfSubjectReview %s is not available: %s", version, err)
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+func createSelfSubjectReview(ctx context.Context, rc *rest.RESTClient, version string) (string, error) {
+	body := fmt.Sprintf(`{"apiVersion":"authentication.k8s.io/%s","kind":"SelfSubjectReview"}`, version)
+	b, err := rc.Post().
+		AbsPath("/apis/authentication.k8s.io", version, "selfsubjectreviews").
+		SetHeader("Content-Type", "application/json").
+		Body([]byte(body)).
+		DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+	// the status is same in all versions
+	var review struct {
+		Status struct {
+			UserInfo authenticationv1.UserInfo `json:"userInfo"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(b, &review); err != nil {
+		return "", fmt.Errorf("invalid response of SelfSubjectReview %s: %w", version, err)
+	}
+	return review.Status.UserInfo.Username, nil
+}
+
+// apply sets the labels and annotations to the pod.
+// The tunnels should be resolved, so that the destinations include the endpoints of a SRV record or service.
+func (a auditInfo) apply(pod *corev1.Pod, tunnels []tunnel.Tunnel) {
+	if v := labelValue(a.Version); v != "" {
+		pod.Labels[versionLabelKey] = v
+	}
+	if a.User != "" {
+		pod.Annotations[userAnnotationKey] = a.User
+	}
+	if a.Hostname != "" {
+		pod.Annotations[hostnameAnnotationKey] = a.Hostname
+	}
+	pod.Annotations[startedAtAnnotationKey] = a.StartedAt.UTC().Format(time.RFC3339)
+	var destinations []string
+	for _, t := range tunnels {
+		destinations = append(destinations, destination(t))
+	}
+	pod.Annotations[destinationsAnnotationKey] = strings.Join(destinations, ",")
+}
+
+// destination returns the remote host and port of the tunnel.
+// If the tunnel has the endpoints, it appends them in form of HOST:PORT=HOST1:PORT1;HOST2:PORT2.
+func destination(t tunnel.Tunnel) string {
+	d := fmt.Sprintf("%s:%d", t.RemoteHost, t.RemotePort)
+	if len(t.Endpoints) == 0 {
+		return d
+	}
+	var endpoints []string
+	for _, e := range t.Upstreams() {
+		endpoints = append(endpoints, net.JoinHostPort(e.Host, strconv.Itoa(e.Port)))
+	}
+	return d + "=" + strings.Join(endpoints, ";")
+}
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// labelValue returns a valid label value, or empty if it cannot be a label value.
+func labelValue(s string) string {
+	s = invalidLabelValueChars.ReplaceAllString(s, "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "._-")
+}
+
+// recordPodEvent creates an event on the pod.
+// It is best-effort and does not return an error.
+func recordPodEvent(ctx context.Context, c kubernetes.Interface, pod *corev1.Pod, reason, message string) {
+	ctx, cancel := context.WithTimeout(ctx, podEventTimeout)
+	defer cancel()
+	now := metav1.Now()
+	ev := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.Name + ".",
+			Namespace:    pod.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			UID:        pod.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeNormal,
+		Source:         corev1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := c.CoreV1().Events(pod.Namespace).Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+		klog.Infof("could not create an event on pod %s/%s: %s", pod.Namespace, pod.Name, err)
+	}
+}
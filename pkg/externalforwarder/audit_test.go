@@ -0,0 +1,142 @@
+package externalforwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestAuditInfo(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("WhoAmI", func(t *testing.T) {
+		whoAmI := func(context.Context, kubernetes.Interface) (string, error) {
+			return "alice@example.com", nil
+		}
+		a := newAuditInfo(ctx, fake.NewSimpleClientset(), whoAmI, "v1.2.3", "kubeconfig-user")
+		if a.User != "alice@example.com" {
+			t.Errorf("User wants alice@example.com but got %s", a.User)
+		}
+	})
+
+	t.Run("KubeconfigUser", func(t *testing.T) {
+		whoAmI := func(context.Context, kubernetes.Interface) (string, error) {
+			return "", errors.New("forbidden")
+		}
+		a := newAuditInfo(ctx, fake.NewSimpleClientset(), whoAmI, "v1.2.3", "kubeconfig-user")
+		if a.User != "kubeconfig-user" {
+			t.Errorf("User wants kubeconfig-user but got %s", a.User)
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		a := auditInfo{
+			Version:   "v1.2.3",
+			User:      "alice@example.com",
+			Hostname:  "alice-laptop",
+			StartedAt: time.Date(2023, 2, 20, 10, 1, 2, 0, time.UTC),
+		}
+		pod, err := newPod([]tunnel.Tunnel{{LocalPort: 15432, RemoteHost: "db", RemotePort: 5432}}, "envoy", envoy.Option{})
+		if err != nil {
+			t.Fatalf("newPod error: %s", err)
+		}
+		a.apply(pod, []tunnel.Tunnel{
+			{RemoteHost: "db", RemotePort: 5432},
+			{
+				RemoteHost: "svc/api.staging",
+				RemotePort: 443,
+				Endpoints:  []tunnel.Endpoint{{Host: "10.1.0.1", Port: 8443}, {Host: "10.1.0.2", Port: 8443}},
+			},
+		})
+		if got := pod.Labels[versionLabelKey]; got != "v1.2.3" {
+			t.Errorf("version label wants v1.2.3 but got %s", got)
+		}
+		want := map[string]string{
+			userAnnotationKey:         "alice@example.com",
+			hostnameAnnotationKey:     "alice-laptop",
+			startedAtAnnotationKey:    "2023-02-20T10:01:02Z",
+			destinationsAnnotationKey: "db:5432,svc/api.staging:443=10.1.0.1:8443;10.1.0.2:8443",
+		}
+		for k, v := range want {
+			if diff := cmp.Diff(v, pod.Annotations[k]); diff != "" {
+				t.Errorf("annotation %s mismatch (-want +got):\n%s", k, diff)
+			}
+		}
+	})
+}
+
+func TestWhoAmI(t *testing.T) {
+	// the server supports only v1beta1
+	var paths []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/k8s/apis/authentication.k8s.io/v1beta1/selfsubjectreviews" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kind":"SelfSubjectReview","status":{"userInfo":{"username":"alice@example.com"}}}`))
+	}))
+	defer s.Close()
+	c, err := kubernetes.NewForConfig(&rest.Config{Host: s.URL + "/k8s"})
+	if err != nil {
+		t.Fatalf("NewForConfig error: %s", err)
+	}
+	user, err := WhoAmI(context.TODO(), c)
+	if err != nil {
+		t.Fatalf("WhoAmI error: %s", err)
+	}
+	if user != "alice@example.com" {
+		t.Errorf("user wants alice@example.com but got %s", user)
+	}
+	want := []string{
+		"/k8s/apis/authentication.k8s.io/v1/selfsubjectreviews",
+		"/k8s/apis/authentication.k8s.io/v1beta1/selfsubjectreviews",
+	}
+	if diff := cmp.Diff(want, paths); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	for in, want := range map[string]string{
+		"v1.2.3":       "v1.2.3",
+		"v1.2.3+dirty": "v1.2.3_dirty",
+		"(devel)":      "devel",
+		"0123456789012345678901234567890123456789012345678901234567890123": "012345678901234567890123456789012345678901234567890123456789012",
+	} {
+		if got := labelValue(in); got != want {
+			t.Errorf("labelValue(%s) wants %s but got %s", in, want, got)
+		}
+	}
+}
+
+func TestRecordPodEvent(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewSimpleClientset()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kubectl-external-forward-abcde", UID: "uid-1"}}
+	recordPodEvent(ctx, c, pod, "TunnelOpened", "tunnel 127.0.0.1:15432 -> db:5432 opened")
+	events, err := c.CoreV1().Events("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List error: %s", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("len(events) wants 1 but got %d", len(events.Items))
+	}
+	ev := events.Items[0]
+	if ev.Reason != "TunnelOpened" || ev.InvolvedObject.UID != "uid-1" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
@@ -0,0 +1,255 @@
+package externalforwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// CheckOption represents an option of the pre-flight check.
+type CheckOption struct {
+	// If true, perform a TLS handshake with the remote host
+	TLS bool
+}
+
+// CheckResult represents the result of the pre-flight check of a tunnel.
+type CheckResult struct {
+	Tunnel tunnel.Tunnel
+	// Latency is the time to connect to the remote host via the pod
+	Latency time.Duration
+	// Err is nil if the remote host is reachable
+	Err error
+}
+
+func (r CheckResult) String() string {
+	remote := fmt.Sprintf("%s:%d", r.Tunnel.RemoteHost, r.Tunnel.RemotePort)
+	if r.Err != nil {
+		return fmt.Sprintf("check %s: FAIL: %s", remote, r.Err)
+	}
+	return fmt.Sprintf("check %s: OK (%s)", remote, r.Latency.Round(time.Millisecond))
+}
+
+// checkPollInterval is the interval to poll the stats of Envoy during a check.
+const checkPollInterval = 100 * time.Millisecond
+
+// checkTimeoutMargin is added to the connect timeout of a tunnel.
+const checkTimeoutMargin = 5 * time.Second
+
+// Check creates the pods and verifies that each remote host is reachable from the pod.
+// It deletes the pods before return, even if it is interrupted.
+func (f ExternalForwarder) Check(ctx context.Context, o Option, co CheckOption) ([]CheckResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if !o.IgnoreInterrupt {
+		// there is no connection to drain during a check
+		sig, stop := notifyInterrupt()
+		defer stop()
+		go handleInterrupt(ctx, cancel, sig, 0, make(chan struct{}), nil)
+	}
+	groups, err := groupTunnels(o, f.newClientset)
+	if err != nil {
+		return nil, err
+	}
+	if err := preflightPermissions(ctx, o, groups, o.reportOut()); err != nil {
+		return nil, err
+	}
+	if err := loadPolicies(ctx, groups, o.PolicyConfigMap); err != nil {
+		return nil, err
+	}
+	if err := createPods(ctx, groups, podOption{
+		image:          o.PodImage,
+		envoy:          envoy.Option{AdminPort: envoyAdminPort},
+		version:        o.Version,
+		kubeconfigUser: o.KubeconfigUser,
+		whoAmI:         f.whoAmI(),
+	}, nil); err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, g := range groups {
+			if err := g.deletePod(nil); err != nil {
+				klog.Info(err)
+			}
+		}
+	}()
+
+	var results []CheckResult
+	for _, g := range groups {
+		if _, err := waitForPodRunning(ctx, g.clientset, g.pod.Namespace, g.pod.Name, 60*time.Second); err != nil {
+			return nil, fmt.Errorf("pod is not running: %w", err)
+		}
+		results = append(results, f.checkPodGroup(ctx, g, co)...)
+	}
+	return results, nil
+}
+
+// checkPodGroup verifies the tunnels of the running pod.
+func (f ExternalForwarder) checkPodGroup(ctx context.Context, g *podGroup, co CheckOption) []CheckResult {
+	admin := f.envoyAdminClient(g.Config, g.pod)
+	var results []CheckResult
+	for i, t := range g.Tunnels {
+		r := f.checkTunnel(ctx, admin, g.Config, g.pod, i, t, co)
+		klog.Info(r)
+		results = append(results, r)
+	}
+	return results
+}
+
+// verifyTunnels checks the tunnels of the running pod before starting the port-forwarders,
+// and returns the tunnels to start.
+// If a check has failed, it behaves according to onFailure:
+// abort returns an error, continue excludes the tunnel, and retry keeps the tunnel.
+func (f ExternalForwarder) verifyTunnels(ctx context.Context, g *podGroup, co CheckOption, onFailure TunnelFailurePolicy, events event.Emitter) ([]tunnel.Tunnel, error) {
+	var tunnels []tunnel.Tunnel
+	for _, r := range f.checkPodGroup(ctx, g, co) {
+		if r.Err == nil {
+			tunnels = append(tunnels, r.Tunnel)
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		switch onFailure {
+		case ContinueOnTunnelFailure:
+			klog.Infof("%s; continuing without the tunnel", r)
+			event.Emit(events, event.Event{Type: event.Error, Pod: podKey(g.pod), Context: g.Context, LocalAddr: localAddr(r.Tunnel), Error: r.String()})
+		case RetryOnTunnelFailure:
+			klog.Infof("%s; starting the tunnel to retry", r)
+			tunnels = append(tunnels, r.Tunnel)
+		default:
+			return nil, fmt.Errorf("check of %s:%d failed: %w", r.Tunnel.RemoteHost, r.Tunnel.RemotePort, r.Err)
+		}
+	}
+	return tunnels, nil
+}
+
+// checkTunnel verifies the tunnel in the following steps:
+//
+//  1. Wait for Envoy to resolve the remote host, if the cluster uses DNS.
+//  2. Connect to the listener of Envoy via port-forwarding and wait for the upstream connection.
+//  3. Perform a TLS handshake with the remote host, if enabled.
+//
+// It determines the result of each step from the stats of Envoy.
+func (f ExternalForwarder) checkTunnel(ctx context.Context, admin envoy.AdminClient, config *rest.Config, pod *corev1.Pod, index int, t tunnel.Tunnel, co CheckOption) CheckResult {
+	r := CheckResult{Tunnel: t}
+	timeout := t.ConnectTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout+checkTimeoutMargin)
+	defer cancel()
+	stat := func(name string) string { return fmt.Sprintf("cluster.cluster_%d.%s", index, name) }
+
+	if t.ClusterType() != "STATIC" {
+		err := pollEnvoyStats(ctx, admin, func(stats map[string]uint64) (bool, error) {
+			if stats[stat("update_success")] > 0 {
+				return true, nil
+			}
+			if stats[stat("update_failure")] > 0 {
+				return true, fmt.Errorf("dns: could not resolve the remote host")
+			}
+			return false, nil
+		})
+		if err != nil {
+			r.Err = err
+			return r
+		}
+	}
+
+	before, err := getEnvoyStats(ctx, admin)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	startedAt := time.Now()
+	conn, err := f.PortForwarder.Dial(ctx, portforwarder.StreamOption{
+		Config:              config,
+		TargetNamespace:     pod.Namespace,
+		TargetPodName:       pod.Name,
+		TargetContainerPort: t.PodPort(),
+	})
+	if err != nil {
+		r.Err = fmt.Errorf("tcp: could not connect to the pod: %w", err)
+		return r
+	}
+	defer conn.Close()
+	err = pollEnvoyStats(ctx, admin, func(stats map[string]uint64) (bool, error) {
+		if stats[stat("upstream_cx_connect_fail")] > before[stat("upstream_cx_connect_fail")] {
+			return true, fmt.Errorf("tcp: could not connect to the remote host")
+		}
+		if stats[stat("upstream_cx_none_healthy")] > before[stat("upstream_cx_none_healthy")] {
+			return true, fmt.Errorf("tcp: no healthy upstream host")
+		}
+		return stats[stat("upstream_cx_active")] > before[stat("upstream_cx_active")], nil
+	})
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Latency = time.Since(startedAt)
+
+	if co.TLS {
+		serverName := t.Upstreams()[0].Host
+		tc := tls.Client(conn, &tls.Config{ServerName: serverName})
+		handshakeDone := make(chan error, 1)
+		go func() {
+			handshakeDone <- tc.Handshake()
+		}()
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+			r.Err = fmt.Errorf("tls: %w", ctx.Err())
+			return r
+		case err := <-handshakeDone:
+			if err != nil {
+				r.Err = fmt.Errorf("tls: %w", err)
+				return r
+			}
+		}
+		r.Latency = time.Since(startedAt)
+	}
+	return r
+}
+
+// pollEnvoyStats calls the function with the stats until it returns true or an error.
+func pollEnvoyStats(ctx context.Context, admin envoy.AdminClient, f func(stats map[string]uint64) (bool, error)) error {
+	for {
+		stats, err := getEnvoyStats(ctx, admin)
+		if err != nil {
+			return err
+		}
+		done, err := f(stats)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out: %w", ctx.Err())
+		case <-time.After(checkPollInterval):
+		}
+	}
+}
+
+func getEnvoyStats(ctx context.Context, admin envoy.AdminClient) (map[string]uint64, error) {
+	stats, err := admin.Stats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the stats of envoy: %w", err)
+	}
+	m := make(map[string]uint64, len(stats))
+	for _, s := range stats {
+		m[s.Name] = uint64(s.Value)
+	}
+	return m, nil
+}
@@ -0,0 +1,94 @@
+package externalforwarder
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/fakeapiserver"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+// TestExternalForwarder_Do_check runs the checks which always fail,
+// because the fake API server does not serve the admin interface of Envoy.
+func TestExternalForwarder_Do_check(t *testing.T) {
+	upstream := fakeapiserver.StartEchoServer(t)
+	f := ExternalForwarder{PortForwarder: &portforwarder.PortForwarder{}}
+	newOption := func(s *fakeapiserver.Server, onFailure TunnelFailurePolicy, events event.Emitter) Option {
+		return Option{
+			Config:    s.Config(),
+			Namespace: "default",
+			Tunnels: []tunnel.Tunnel{
+				{LocalHost: "127.0.0.1", RemoteHost: upstream.IP.String(), RemotePort: upstream.Port, ContainerPort: 10000},
+			},
+			PodImage:            DefaultPodImage,
+			Check:               &CheckOption{},
+			Events:              events,
+			OnTunnelFailure:     onFailure,
+			PortForwarderOut:    io.Discard,
+			PortForwarderErrOut: io.Discard,
+			IgnoreInterrupt:     true,
+		}
+	}
+
+	t.Run("Abort", func(t *testing.T) {
+		s := fakeapiserver.New()
+		defer s.Close()
+		err := f.Do(context.TODO(), newOption(s, AbortOnTunnelFailure, nil))
+		if err == nil || !strings.Contains(err.Error(), "check of ") {
+			t.Errorf("error wants the check failure but got %v", err)
+		}
+		if pods := s.Pods(); len(pods) != 0 {
+			t.Errorf("pods want none but got %d", len(pods))
+		}
+	})
+
+	testCases := map[string]struct {
+		onFailure TunnelFailurePolicy
+		want      event.Type
+	}{
+		"Continue": {ContinueOnTunnelFailure, event.Error},
+		"Retry":    {RetryOnTunnelFailure, event.TunnelReady},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := fakeapiserver.New()
+			defer s.Close()
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			var once sync.Once
+			received := make(chan event.Event, 1)
+			events := emitterFunc(func(e event.Event) {
+				if (e.Type == event.Error || e.Type == event.TunnelReady) && e.LocalAddr != "" {
+					once.Do(func() { received <- e })
+				}
+			})
+			done := make(chan error, 1)
+			go func() {
+				done <- f.Do(ctx, newOption(s, testCase.onFailure, events))
+			}()
+			select {
+			case e := <-received:
+				if e.Type != testCase.want {
+					t.Errorf("event wants %s but got %+v", testCase.want, e)
+				}
+			case err := <-done:
+				t.Fatalf("Do returned before the event: %v", err)
+			case <-time.After(30 * time.Second):
+				t.Fatalf("no event of the tunnel")
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Do error: %s", err)
+			}
+			if pods := s.Pods(); len(pods) != 0 {
+				t.Errorf("pods want none but got %d", len(pods))
+			}
+		})
+	}
+}
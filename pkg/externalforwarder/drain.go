@@ -0,0 +1,175 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// drainPollInterval is the interval to check if all connections are finished.
+	drainPollInterval = 100 * time.Millisecond
+	// drainReportInterval is the interval to print the active connections during a drain.
+	drainReportInterval = 5 * time.Second
+)
+
+// interruptSignals are the signals to stop the tunnels and delete the pods.
+// SIGTERM is sent by kill command, e.g., to a daemon.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// notifyInterrupt returns a channel which receives the interrupt signals.
+// It registers the handler before return, so that a signal does not kill the process after that.
+// Caller must call the stop function.
+func notifyInterrupt() (chan os.Signal, func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, interruptSignals...)
+	return sig, func() { signal.Stop(sig) }
+}
+
+// forwardInterrupt sends an interrupt to sig for each request until the context is done.
+func forwardInterrupt(ctx context.Context, requests <-chan struct{}, sig chan<- os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-requests:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case sig <- os.Interrupt:
+		}
+	}
+}
+
+// handleInterrupt cancels the context when sig receives an interrupt.
+// If drainTimeout is set and there are active connections, it closes drainChan
+// and waits for the connections to finish up to the timeout before cancel.
+// The second interrupt cancels the context immediately.
+func handleInterrupt(ctx context.Context, cancel context.CancelFunc, sig <-chan os.Signal, drainTimeout time.Duration, drainChan chan struct{}, tracker *connectionTracker) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-sig:
+	}
+	if drainTimeout == 0 || len(tracker.active()) == 0 {
+		cancel()
+		return
+	}
+
+	klog.Infof("draining the connections up to %s (press Ctrl-C again to stop immediately)", drainTimeout)
+	close(drainChan)
+	tracker.report()
+	timeout := time.NewTimer(drainTimeout)
+	defer timeout.Stop()
+	poll := time.NewTicker(drainPollInterval)
+	defer poll.Stop()
+	report := time.NewTicker(drainReportInterval)
+	defer report.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			klog.Infof("interrupted again, closing the connections")
+			cancel()
+			return
+		case <-timeout.C:
+			klog.Infof("drain timeout exceeded, closing the connections")
+			tracker.report()
+			cancel()
+			return
+		case <-report.C:
+			tracker.report()
+		case <-poll.C:
+			if len(tracker.active()) == 0 {
+				klog.Infof("all connections are finished")
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// connectionTracker tracks the active connections of the tunnels.
+// It is safe for concurrent use.
+type connectionTracker struct {
+	mu sync.Mutex
+	// a connection is identified by the client address and tunnel
+	connections map[string]bool
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{connections: make(map[string]bool)}
+}
+
+// Observer returns an observer which tracks the connections of the tunnel.
+// If the receiver is nil, it returns nil.
+func (t *connectionTracker) Observer(tunnelName, remote string) portforwarder.Observer {
+	if t == nil {
+		return nil
+	}
+	return trackerObserver{tracker: t, tunnel: tunnelName, remote: remote}
+}
+
+// active returns the active connections in order.
+func (t *connectionTracker) active() []string {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var connections []string
+	for c := range t.connections {
+		connections = append(connections, c)
+	}
+	sort.Strings(connections)
+	return connections
+}
+
+func (t *connectionTracker) report() {
+	active := t.active()
+	if len(active) == 0 {
+		return
+	}
+	klog.Infof("waiting for %d connection(s):\n  %s", len(active), strings.Join(active, "\n  "))
+}
+
+// trackerObserver records the connections of a tunnel into the tracker.
+type trackerObserver struct {
+	tracker *connectionTracker
+	tunnel  string
+	remote  string
+}
+
+func (o trackerObserver) key(client net.Addr) string {
+	return fmt.Sprintf("%s -> %s -> %s", client, o.tunnel, o.remote)
+}
+
+func (o trackerObserver) ConnectionOpened(client net.Addr) {
+	o.tracker.mu.Lock()
+	defer o.tracker.mu.Unlock()
+	o.tracker.connections[o.key(client)] = true
+}
+
+func (o trackerObserver) ConnectionClosed(client net.Addr) {
+	o.tracker.mu.Lock()
+	defer o.tracker.mu.Unlock()
+	delete(o.tracker.connections, o.key(client))
+}
+
+func (o trackerObserver) Ready(net.Addr)        {}
+func (o trackerObserver) BytesSent(int)         {}
+func (o trackerObserver) BytesReceived(int)     {}
+func (o trackerObserver) ConnectionError(error) {}
+func (o trackerObserver) Reconnecting()         {}
@@ -0,0 +1,159 @@
+package externalforwarder
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConnectionTracker(t *testing.T) {
+	tracker := newConnectionTracker()
+	db := tracker.Observer("127.0.0.1:15432", "db:5432")
+	api := tracker.Observer("127.0.0.1:10443", "api:443")
+	client1 := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50001}
+	client2 := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50002}
+
+	db.ConnectionOpened(client1)
+	api.ConnectionOpened(client2)
+	want := []string{
+		"127.0.0.1:50001 -> 127.0.0.1:15432 -> db:5432",
+		"127.0.0.1:50002 -> 127.0.0.1:10443 -> api:443",
+	}
+	if diff := cmp.Diff(want, tracker.active()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	db.ConnectionClosed(client1)
+	api.ConnectionClosed(client2)
+	if got := tracker.active(); len(got) != 0 {
+		t.Errorf("active wants empty but got %v", got)
+	}
+
+	var nilTracker *connectionTracker
+	if o := nilTracker.Observer("127.0.0.1:15432", "db:5432"); o != nil {
+		t.Errorf("Observer wants nil but got %v", o)
+	}
+}
+
+func TestHandleInterrupt(t *testing.T) {
+	client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50001}
+	type handler struct {
+		ctx       context.Context
+		sig       chan os.Signal
+		drainChan chan struct{}
+		tracker   *connectionTracker
+		done      chan struct{}
+	}
+	start := func(t *testing.T, drainTimeout time.Duration) handler {
+		ctx, cancel := context.WithCancel(context.TODO())
+		t.Cleanup(cancel)
+		h := handler{
+			ctx:       ctx,
+			sig:       make(chan os.Signal, 1),
+			drainChan: make(chan struct{}),
+			tracker:   newConnectionTracker(),
+			done:      make(chan struct{}),
+		}
+		go func() {
+			defer close(h.done)
+			handleInterrupt(ctx, cancel, h.sig, drainTimeout, h.drainChan, h.tracker)
+		}()
+		return h
+	}
+	waitForCancel := func(t *testing.T, h handler) {
+		t.Helper()
+		select {
+		case <-h.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handleInterrupt did not return")
+		}
+		if h.ctx.Err() == nil {
+			t.Errorf("context wants canceled")
+		}
+	}
+	isDraining := func(h handler) bool {
+		select {
+		case <-h.drainChan:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Run("NoConnection", func(t *testing.T) {
+		h := start(t, time.Minute)
+		h.sig <- os.Interrupt
+		waitForCancel(t, h)
+		if isDraining(h) {
+			t.Errorf("drainChan wants open")
+		}
+	})
+
+	t.Run("NoDrainTimeout", func(t *testing.T) {
+		h := start(t, 0)
+		h.tracker.Observer("127.0.0.1:15432", "db:5432").ConnectionOpened(client)
+		h.sig <- os.Interrupt
+		waitForCancel(t, h)
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		h := start(t, time.Minute)
+		db := h.tracker.Observer("127.0.0.1:15432", "db:5432")
+		db.ConnectionOpened(client)
+		h.sig <- os.Interrupt
+		select {
+		case <-h.drainChan:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("drainChan wants closed")
+		}
+		time.Sleep(3 * drainPollInterval)
+		if h.ctx.Err() != nil {
+			t.Fatalf("context wants not canceled while a connection is active")
+		}
+		db.ConnectionClosed(client)
+		waitForCancel(t, h)
+	})
+
+	t.Run("SecondInterrupt", func(t *testing.T) {
+		h := start(t, time.Minute)
+		h.tracker.Observer("127.0.0.1:15432", "db:5432").ConnectionOpened(client)
+		h.sig <- os.Interrupt
+		<-h.drainChan
+		h.sig <- os.Interrupt
+		waitForCancel(t, h)
+	})
+
+	t.Run("DrainTimeout", func(t *testing.T) {
+		h := start(t, 200*time.Millisecond)
+		h.tracker.Observer("127.0.0.1:15432", "db:5432").ConnectionOpened(client)
+		h.sig <- os.Interrupt
+		waitForCancel(t, h)
+		if !isDraining(h) {
+			t.Errorf("drainChan wants closed")
+		}
+	})
+}
+
+func TestForwardInterrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	requests := make(chan struct{})
+	sig := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		forwardInterrupt(ctx, requests, sig)
+	}()
+	for i := 0; i < 2; i++ {
+		requests <- struct{}{}
+		if got := <-sig; got != os.Interrupt {
+			t.Errorf("signal wants %v but got %v", os.Interrupt, got)
+		}
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,101 @@
+package externalforwarder
+
+import (
+	"net"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+)
+
+// eventObserver emits the events of a tunnel.
+type eventObserver struct {
+	emitter   event.Emitter
+	pod       string
+	context   string
+	localAddr string
+	remote    string
+}
+
+func (o eventObserver) Ready(addr net.Addr) {
+	event.Emit(o.emitter, event.Event{Type: event.TunnelReady, Pod: o.pod, Context: o.context, LocalAddr: addr.String(), Remote: o.remote})
+}
+
+func (o eventObserver) ConnectionOpened(client net.Addr) {
+	event.Emit(o.emitter, event.Event{Type: event.ConnectionOpened, Pod: o.pod, Context: o.context, LocalAddr: o.localAddr, Remote: o.remote, Client: client.String()})
+}
+
+func (o eventObserver) ConnectionClosed(client net.Addr) {
+	event.Emit(o.emitter, event.Event{Type: event.ConnectionClosed, Pod: o.pod, Context: o.context, LocalAddr: o.localAddr, Remote: o.remote, Client: client.String()})
+}
+
+func (o eventObserver) BytesSent(int) {}
+
+func (o eventObserver) BytesReceived(int) {}
+
+func (o eventObserver) ConnectionError(err error) {
+	event.Emit(o.emitter, event.Event{Type: event.ConnectionError, Pod: o.pod, Context: o.context, LocalAddr: o.localAddr, Remote: o.remote, Error: err.Error()})
+}
+
+func (o eventObserver) Reconnecting() {
+	event.Emit(o.emitter, event.Event{Type: event.Reconnecting, Pod: o.pod, Context: o.context, LocalAddr: o.localAddr, Remote: o.remote})
+}
+
+// observers notifies all observers.
+type observers []portforwarder.Observer
+
+// newObservers returns an observer which notifies the non-nil observers.
+// It returns nil if no observer is given.
+func newObservers(all ...portforwarder.Observer) portforwarder.Observer {
+	var o observers
+	for _, observer := range all {
+		if observer != nil {
+			o = append(o, observer)
+		}
+	}
+	if len(o) == 0 {
+		return nil
+	}
+	return o
+}
+
+func (o observers) Ready(addr net.Addr) {
+	for _, observer := range o {
+		observer.Ready(addr)
+	}
+}
+
+func (o observers) ConnectionOpened(client net.Addr) {
+	for _, observer := range o {
+		observer.ConnectionOpened(client)
+	}
+}
+
+func (o observers) ConnectionClosed(client net.Addr) {
+	for _, observer := range o {
+		observer.ConnectionClosed(client)
+	}
+}
+
+func (o observers) BytesSent(n int) {
+	for _, observer := range o {
+		observer.BytesSent(n)
+	}
+}
+
+func (o observers) BytesReceived(n int) {
+	for _, observer := range o {
+		observer.BytesReceived(n)
+	}
+}
+
+func (o observers) ConnectionError(err error) {
+	for _, observer := range o {
+		observer.ConnectionError(err)
+	}
+}
+
+func (o observers) Reconnecting() {
+	for _, observer := range o {
+		observer.Reconnecting()
+	}
+}
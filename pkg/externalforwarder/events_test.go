@@ -0,0 +1,49 @@
+package externalforwarder
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+)
+
+func TestEventObserver(t *testing.T) {
+	var got []event.Event
+	o := eventObserver{
+		emitter:   emitterFunc(func(e event.Event) { got = append(got, e) }),
+		pod:       "default/kubectl-external-forward-abcde",
+		context:   "staging",
+		localAddr: "127.0.0.1:15432",
+		remote:    "db:5432",
+	}
+	client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50001}
+	o.Ready(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 15432})
+	o.ConnectionOpened(client)
+	o.ConnectionError(errors.New("connection refused"))
+	o.ConnectionClosed(client)
+	o.Reconnecting()
+
+	base := event.Event{Pod: "default/kubectl-external-forward-abcde", Context: "staging", LocalAddr: "127.0.0.1:15432", Remote: "db:5432"}
+	with := func(typ event.Type, f func(e *event.Event)) event.Event {
+		e := base
+		e.Type = typ
+		if f != nil {
+			f(&e)
+		}
+		return e
+	}
+	want := []event.Event{
+		with(event.TunnelReady, nil),
+		with(event.ConnectionOpened, func(e *event.Event) { e.Client = "127.0.0.1:50001" }),
+		with(event.ConnectionError, func(e *event.Event) { e.Error = "connection refused" }),
+		with(event.ConnectionClosed, func(e *event.Event) { e.Client = "127.0.0.1:50001" }),
+		with(event.Reconnecting, nil),
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreTypes(time.Time{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -3,118 +3,624 @@ package externalforwarder
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"os/signal"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/google/wire"
+	"github.com/int128/kubectl-external-forward/pkg/dnsserver"
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/metrics"
 	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
 	"github.com/int128/kubectl-external-forward/pkg/tunnel"
 	"golang.org/x/sync/errgroup"
-	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
 	"k8s.io/klog/v2"
 )
 
+// DefaultPodImage is the image of Envoy used by default.
+const DefaultPodImage = "ghcr.io/int128/kubectl-external-forward/mirror/envoy"
+
+// DefaultPolicyConfigMap is the ConfigMap of the destination policy used by default.
+const DefaultPolicyConfigMap = "kube-system/external-forward-policy"
+
 var Set = wire.NewSet(
 	wire.Struct(new(ExternalForwarder), "*"),
 	wire.Bind(new(Interface), new(*ExternalForwarder)),
 )
 
 type Option struct {
+	// Config and Namespace are used for the tunnels without a context
 	Config    *rest.Config
-	Tunnels   []tunnel.Tunnel
 	Namespace string
-	PodImage  string
+	// ContextConfigs maps a kubeconfig context name to the config.
+	// It must contain the contexts of all tunnels.
+	ContextConfigs map[string]*rest.Config
+	// Tunnels to open. If the local port of a tunnel is zero, an available port is allocated.
+	// The monitor receives the tunnel with the allocated port.
+	Tunnels  []tunnel.Tunnel
+	PodImage string
+	// If set, write the remote hostnames into the hosts file
+	HostsFile string
+	// If set, run a DNS server which answers the remote hostnames
+	DNSServerAddr string
+	// If set, write the access logs into the file
+	AccessLogFile string
+	// If set, serve the metrics on the address
+	MetricsAddr string
+	// If set, forward the local port to the admin interface of Envoy.
+	// If there are multiple pods, the subsequent ports are used.
+	AdminPort int
+	// If set, verify the remote hosts from the pod before starting the port-forwarders
+	Check *CheckOption
+	// If set, emit the events of the pods and tunnels
+	Events event.Emitter
+	// If set, write the messages of the port-forwarders. Default to stdout
+	PortForwarderOut io.Writer
+	// If set, write the error messages of the port-forwarders. Default to stderr
+	PortForwarderErrOut io.Writer
+	// If set, write the report of the missing permissions. Default to stderr
+	ReportOut io.Writer
+	// If set, observe the tunnels and accept the requests to reconnect
+	Monitor TunnelMonitor
+	// If set, refuse the tunnels which are not allowed by the policy in the ConfigMap of NAMESPACE/NAME
+	PolicyConfigMap string
+	// If true, create a network policy which allows egress only to the destinations
+	NetworkPolicy bool
+	// If true, the network policy allows the port of a host which could not be resolved to any address.
+	// Otherwise, an unresolved host is an error
+	AllowUnresolved bool
+	// EgressCIDRs maps a remote host to the CIDRs which the network policy allows.
+	// A host outside the cluster needs the CIDRs, because the addresses in the cluster are not known beforehand
+	EgressCIDRs map[string][]string
+	// Version of the plugin, recorded in the label of the pod
+	Version string
+	// User of kubeconfig, recorded in the annotation if SelfSubjectReview is not available
+	KubeconfigUser string
+	// Behavior when a port-forwarder fails. Default to AbortOnTunnelFailure.
+	// It must be AbortOnTunnelFailure in the lazy mode
+	OnTunnelFailure TunnelFailurePolicy
+	// If set, create the pods on the first connection and delete them when idle
+	Lazy *LazyOption
+	// If set, wait for the active connections to finish up to the duration on interrupt
+	DrainTimeout time.Duration
+	// If true, do not handle the interrupt signal, and the caller stops by cancel of the context
+	IgnoreInterrupt bool
+	// If set, a request stops the tunnels in the same way as the interrupt signal.
+	// It works even if IgnoreInterrupt is true
+	Interrupt <-chan struct{}
+}
+
+func (o Option) reportOut() io.Writer {
+	if o.ReportOut == nil {
+		return os.Stderr
+	}
+	return o.ReportOut
+}
+
+// TunnelMonitor observes the tunnels and requests to reconnect them.
+// It must be safe for concurrent use.
+type TunnelMonitor interface {
+	// Observer returns an observer of the tunnel, or nil
+	Observer(t tunnel.Tunnel) portforwarder.Observer
+	// Reconnect returns a channel which receives the requests to reconnect the tunnel, or nil
+	Reconnect(t tunnel.Tunnel) <-chan struct{}
 }
 
+// TunnelFailurePolicy represents the behavior when a port-forwarder fails.
+type TunnelFailurePolicy string
+
+const (
+	// AbortOnTunnelFailure stops all tunnels and deletes the pods
+	AbortOnTunnelFailure TunnelFailurePolicy = "abort"
+	// ContinueOnTunnelFailure stops the failed tunnel and keeps the others
+	ContinueOnTunnelFailure TunnelFailurePolicy = "continue"
+	// RetryOnTunnelFailure restarts the failed tunnel with exponential backoff
+	RetryOnTunnelFailure TunnelFailurePolicy = "retry"
+)
+
+// TunnelFailurePolicies is the list of the valid values of TunnelFailurePolicy.
+var TunnelFailurePolicies = []TunnelFailurePolicy{AbortOnTunnelFailure, ContinueOnTunnelFailure, RetryOnTunnelFailure}
+
 type Interface interface {
 	Do(ctx context.Context, o Option) error
+	Stdio(ctx context.Context, o StdioOption) error
+	Check(ctx context.Context, o Option, co CheckOption) ([]CheckResult, error)
 }
 
+// NewClientsetFunc creates a Kubernetes client from the config.
+type NewClientsetFunc func(config *rest.Config) (kubernetes.Interface, error)
+
 type ExternalForwarder struct {
 	PortForwarder portforwarder.Interface
+	NewClientset  NewClientsetFunc
+	WhoAmI        WhoAmIFunc
 }
 
-func (f ExternalForwarder) Do(ctx context.Context, o Option) error {
-	clientset, err := kubernetes.NewForConfig(o.Config)
+// newClientset creates a Kubernetes client by NewClientset.
+// If NewClientset is nil, it creates a client of the config.
+func (f ExternalForwarder) newClientset(config *rest.Config) (kubernetes.Interface, error) {
+	if f.NewClientset == nil {
+		return kubernetes.NewForConfig(config)
+	}
+	return f.NewClientset(config)
+}
+
+// whoAmI returns WhoAmI of the forwarder.
+// If WhoAmI is nil, it returns the function of SelfSubjectReview.
+func (f ExternalForwarder) whoAmI() WhoAmIFunc {
+	if f.WhoAmI == nil {
+		return WhoAmI
+	}
+	return f.WhoAmI
+}
+
+func (f ExternalForwarder) Do(ctx context.Context, o Option) (err error) {
+	defer func() {
+		if err != nil {
+			event.Emit(o.Events, event.Event{Type: event.Error, Error: err.Error()})
+		}
+	}()
+	if o.Lazy != nil && o.OnTunnelFailure != "" && o.OnTunnelFailure != AbortOnTunnelFailure {
+		// a failed port-forwarder ends the lifecycle of the pod, and the next connection recreates it
+		return fmt.Errorf("on-tunnel-failure %s cannot be used with the lazy mode", o.OnTunnelFailure)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	drainChan := make(chan struct{})
+	connections := newConnectionTracker()
+	if !o.IgnoreInterrupt || o.Interrupt != nil {
+		sig := make(chan os.Signal, 1)
+		if !o.IgnoreInterrupt {
+			// register the handler before creating any pod, otherwise an interrupt leaks the pods
+			var stop func()
+			sig, stop = notifyInterrupt()
+			defer stop()
+		}
+		if o.Interrupt != nil {
+			go forwardInterrupt(ctx, o.Interrupt, sig)
+		}
+		go handleInterrupt(ctx, cancel, sig, o.DrainTimeout, drainChan, connections)
+	}
+	groups, err := groupTunnels(o, f.newClientset)
 	if err != nil {
-		return fmt.Errorf("could not create a client set: %w", err)
+		return err
+	}
+	if o.AdminPort != 0 {
+		for i, g := range groups {
+			g.AdminLocalPort = o.AdminPort + i
+		}
+	}
+	// fail fast before any API calls if a local port is in use
+	if err := listenGroups(groups); err != nil {
+		return err
+	}
+	defer closeListeners(groups)
+	if err := preflightPermissions(ctx, o, groups, o.reportOut()); err != nil {
+		return err
+	}
+	if err := loadPolicies(ctx, groups, o.PolicyConfigMap); err != nil {
+		return err
+	}
+	var accessLogWriter io.Writer
+	if o.AccessLogFile != "" {
+		f, err := os.OpenFile(o.AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open the access log file: %w", err)
+		}
+		defer f.Close()
+		accessLogWriter = &syncWriter{w: f}
+	}
+	pgo := podGroupOption{
+		accessLogWriter:  accessLogWriter,
+		check:            o.Check,
+		events:           o.Events,
+		portForwarderOut: o.PortForwarderOut,
+		portForwarderErr: o.PortForwarderErrOut,
+		monitor:          o.Monitor,
+		onTunnelFailure:  o.OnTunnelFailure,
+		drainChan:        drainChan,
+		connections:      connections,
+	}
+	var envoyOption envoy.Option
+	if o.MetricsAddr != "" {
+		pgo.metrics = metrics.New()
+		envoyOption.AdminPort = envoyAdminPort
+	}
+	if o.Check != nil {
+		envoyOption.AdminPort = envoyAdminPort
+	}
+	if o.AdminPort != 0 {
+		envoyOption.AdminPort = envoyAdminPort
+	}
+	po := podOption{
+		image:           o.PodImage,
+		envoy:           envoyOption,
+		networkPolicy:   o.NetworkPolicy,
+		allowUnresolved: o.AllowUnresolved,
+		egressCIDRs:     o.EgressCIDRs,
+		whoAmI:          f.whoAmI(),
+		version:         o.Version,
+		kubeconfigUser:  o.KubeconfigUser,
+	}
+	if o.HostsFile != "" {
+		removeHostsFileEntries, err := addHostsFileEntries(o.HostsFile, o.Tunnels)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if rerr := removeHostsFileEntries(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+	if o.Lazy == nil {
+		if err := createPods(ctx, groups, po, o.Events); err != nil {
+			return err
+		}
+		// the pods are deleted on cancel, but make sure even if a goroutine did not start
+		defer func() {
+			for _, g := range groups {
+				if err := g.deletePod(o.Events); err != nil {
+					klog.Info(err)
+				}
+			}
+		}()
+	}
+
+	// any error cancels the context and stops all goroutines
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, g := range groups {
+		if o.Lazy != nil {
+			f.startLazyPodGroup(ctx, eg, g, po, pgo, *o.Lazy)
+			continue
+		}
+		f.startPodGroup(ctx, eg, g, pgo)
 	}
+	if pgo.metrics != nil {
+		eg.Go(func() error {
+			return pgo.metrics.Serve(ctx, o.MetricsAddr)
+		})
+	}
+	if o.DNSServerAddr != "" {
+		eg.Go(func() error {
+			s := dnsserver.Server{Addr: o.DNSServerAddr, Records: hostRecords(o.Tunnels)}
+			return s.Run(ctx)
+		})
+	}
+	return eg.Wait()
+}
+
+// podOption represents the options to create the pods.
+type podOption struct {
+	image           string
+	envoy           envoy.Option
+	networkPolicy   bool
+	allowUnresolved bool
+	egressCIDRs     map[string][]string
+	whoAmI          WhoAmIFunc
+	version         string
+	kubeconfigUser  string
+}
 
-	klog.Infof("creating a pod")
-	pod, err := newPod(o)
+// createPods creates a pod for each group.
+// If any pod could not be created, it deletes the created pods.
+func createPods(ctx context.Context, groups []*podGroup, po podOption, events event.Emitter) error {
+	for i, g := range groups {
+		if err := createPod(ctx, g, po, events); err != nil {
+			for _, created := range groups[:i] {
+				if err := created.deletePod(events); err != nil {
+					klog.Info(err)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func createPod(ctx context.Context, g *podGroup, po podOption, events event.Emitter) error {
+	clientset := g.clientset
+	tunnels, err := resolveTunnels(ctx, clientset, g.Namespace, g.Tunnels)
+	if err != nil {
+		return err
+	}
+	if err := enforcePolicy(g.policy, g.Namespace, g.Tunnels, tunnels); err != nil {
+		return err
+	}
+	klog.Infof("creating a pod in %s", g)
+	pod, err := newPod(tunnels, po.image, po.envoy)
 	if err != nil {
 		return fmt.Errorf("could not generate pod spec: %w", err)
 	}
-	pod, err = clientset.CoreV1().Pods(o.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	whoAmI := po.whoAmI
+	if whoAmI == nil {
+		whoAmI = WhoAmI
+	}
+	g.audit = newAuditInfo(ctx, clientset, whoAmI, po.version, po.kubeconfigUser)
+	g.audit.apply(pod, tunnels)
+	g.Tunnels = tunnels
+	if g.policy != nil {
+		pod.Annotations[policyVersionAnnotationKey] = g.policy.Version
+	}
+	var np *networkingv1.NetworkPolicy
+	if po.networkPolicy {
+		instanceID, err := newInstanceID()
+		if err != nil {
+			return err
+		}
+		pod.Labels[instanceLabelKey] = instanceID
+		np, err = createNetworkPolicy(ctx, clientset, g.Namespace, instanceID, g.Tunnels, po)
+		if err != nil {
+			return err
+		}
+	}
+	pod, err = clientset.CoreV1().Pods(g.Namespace).Create(ctx, pod, metav1.CreateOptions{})
 	if err != nil {
+		if np != nil {
+			deleteNetworkPolicy(clientset, np)
+		}
 		return fmt.Errorf("could not create pod: %w", err)
 	}
 	klog.Infof("created pod %s/%s", pod.Namespace, pod.Name)
+	event.Emit(events, event.Event{Type: event.PodCreated, Pod: podKey(pod), Context: g.Context})
+	if np != nil {
+		if err := setNetworkPolicyOwner(ctx, clientset, np, pod); err != nil {
+			if err := cleanupPod(clientset, pod, g.Context, events); err != nil {
+				klog.Info(err)
+			}
+			deleteNetworkPolicy(clientset, np)
+			return err
+		}
+	}
+	g.pod = pod
+	g.createdAt = time.Now()
+	return nil
+}
+
+// podGroupOption represents the options shared by the pod groups.
+type podGroupOption struct {
+	accessLogWriter io.Writer
+	metrics         *metrics.Metrics
+	check           *CheckOption
+	events          event.Emitter
+	// if nil, write the messages of the port-forwarders to stdout
+	portForwarderOut io.Writer
+	portForwarderErr io.Writer
+	monitor          TunnelMonitor
+	onTunnelFailure  TunnelFailurePolicy
+	// closed on the drain phase of shutdown
+	drainChan   <-chan struct{}
+	connections *connectionTracker
+}
 
-	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
-	defer stop()
-	var eg errgroup.Group
+func (f ExternalForwarder) startPodGroup(ctx context.Context, eg *errgroup.Group, g *podGroup, pgo podGroupOption) {
+	clientset, pod, m := g.clientset, g.pod, pgo.metrics
 	eg.Go(func() error {
 		<-ctx.Done()
-
-		// clean up the pod
-		ctx := context.Background()
-		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
-		defer stop()
-		klog.Infof("deleting pod %s/%s...", pod.Namespace, pod.Name)
-		if err := deletePodWithRetry(ctx, clientset, pod.Namespace, pod.Name, 60*time.Second); err != nil {
-			return fmt.Errorf("you need to delete pod %s/%s manually: %w", pod.Namespace, pod.Name, err)
-		}
-		klog.Infof("deleted pod %s/%s", pod.Namespace, pod.Name)
-		return nil
+		m.RemoveEnvoy(podKey(pod))
+		return g.deletePod(pgo.events)
 	})
 
 	eg.Go(func() error {
-		if err := waitForPodRunning(ctx, clientset, pod.Namespace, pod.Name, 60*time.Second); err != nil {
-			return fmt.Errorf("pod is not running: %w", err)
+		running, err := waitForPodRunning(ctx, clientset, pod.Namespace, pod.Name, 60*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("pod %s is not running: %w", podKey(pod), err)
 		}
+		event.Emit(pgo.events, event.Event{Type: event.PodRunning, Pod: podKey(pod), Context: g.Context, Node: running.Spec.NodeName})
+		m.PodStartup(time.Since(g.createdAt))
+		m.AddEnvoy(podKey(pod), f.envoyStatsFetcher(g.Config, pod))
 
 		for _, container := range pod.Spec.Containers {
 			containerName := container.Name
 			eg.Go(func() error {
-				return tailPodLogs(ctx, clientset, pod.Namespace, pod.Name, containerName)
+				handleLine := func(line string) {
+					if accessLog, ok := envoy.ParseAccessLog(line); ok {
+						f.handleAccessLog(g, accessLog, pgo.accessLogWriter)
+						return
+					}
+					klog.Infof("%s/%s/%s: %s", pod.Namespace, pod.Name, containerName, line)
+				}
+				if err := tailPodLogs(ctx, clientset, pod.Namespace, pod.Name, containerName, handleLine); err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fmt.Errorf("could not tail the logs of %s/%s: %w", podKey(pod), containerName, err)
+				}
+				return nil
 			})
 		}
 
-		for _, t := range o.Tunnels {
-			f.startPortForwarder(ctx, &eg, o.Config, t, pod)
+		tunnels := g.Tunnels
+		if pgo.check != nil {
+			tunnels, err = f.verifyTunnels(ctx, g, *pgo.check, pgo.onTunnelFailure, pgo.events)
+			if err != nil {
+				return err
+			}
+		}
+		for _, t := range tunnels {
+			f.startPortForwarder(ctx, eg, g, t, &pgo, pgo.onTunnelFailure)
+		}
+		if g.AdminLocalPort != 0 {
+			klog.Infof("admin interface of envoy in %s is available at http://127.0.0.1:%d", g, g.AdminLocalPort)
+			f.startPortForwarder(ctx, eg, g, adminTunnel(g), nil, pgo.onTunnelFailure)
 		}
 		return nil
 	})
-	return eg.Wait()
 }
 
-func (f ExternalForwarder) startPortForwarder(ctx context.Context, eg *errgroup.Group, restConfig *rest.Config, tunnel tunnel.Tunnel, pod *corev1.Pod) {
-	stopChan := make(chan struct{})
-	eg.Go(func() error {
-		<-ctx.Done()
-		close(stopChan)
-		return nil
-	})
-	eg.Go(func() error {
-		klog.Infof("starting port-forwarder from %d to %s/%s:%d", tunnel.LocalPort, pod.Namespace, pod.Name, tunnel.LocalPort)
+// startPortForwarder starts a port-forwarder of the tunnel.
+// If pgo is nil, it does not record the metrics and events.
+// If the port-forwarder fails, it behaves according to onFailure.
+//
+// Each tunnel has its own port-forwarder rather than sharing one with the other tunnels of the pod,
+// because a failure, retry or reconnect of a port-forwarder affects all of its ports.
+func (f ExternalForwarder) startPortForwarder(ctx context.Context, eg *errgroup.Group, g *podGroup, tunnel tunnel.Tunnel, pgo *podGroupOption, onFailure TunnelFailurePolicy) {
+	pod := g.pod
+	tunnelName := localAddr(tunnel)
+	// the listener is used at the first attempt, and the port-forwarder listens again on retry
+	listener := g.listeners[tunnelName]
+	var m *metrics.Metrics
+	var events event.Emitter
+	var observer portforwarder.Observer
+	var logger portforwarder.Logger
+	var drain, reconnect <-chan struct{}
+	if pgo != nil {
+		m = pgo.metrics
+		events = pgo.events
+		remote := fmt.Sprintf("%s:%d", tunnel.RemoteHost, tunnel.RemotePort)
+		var eo portforwarder.Observer
+		if pgo.events != nil {
+			eo = eventObserver{
+				emitter:   pgo.events,
+				pod:       podKey(pod),
+				context:   g.Context,
+				localAddr: tunnelName,
+				remote:    remote,
+			}
+		}
+		var mo portforwarder.Observer
+		if pgo.monitor != nil {
+			mo = pgo.monitor.Observer(tunnel)
+			reconnect = pgo.monitor.Reconnect(tunnel)
+		}
+		observer = newObservers(m.Observer(tunnelName), eo, pgo.connections.Observer(tunnelName, remote), mo)
+		logger = portforwarder.WriterLogger{Out: pgo.portForwarderOut, ErrOut: pgo.portForwarderErr}
+		drain = pgo.drainChan
+	}
+	message := fmt.Sprintf("tunnel %s -> %s:%d", tunnelName, tunnel.RemoteHost, tunnel.RemotePort)
+	if g.audit.User != "" {
+		message += fmt.Sprintf(" by %s@%s", g.audit.User, g.audit.Hostname)
+	}
+	run := func() error {
+		klog.Infof("starting port-forwarder from %s to %s/%s:%d", tunnelName, pod.Namespace, pod.Name, tunnel.PodPort())
+		// opened is closed when the event of the opened tunnel is recorded
+		var opened chan struct{}
 		po := portforwarder.Option{
-			Config:              restConfig,
-			SourceHost:          tunnel.LocalHost,
-			SourcePort:          tunnel.LocalPort,
-			TargetNamespace:     pod.Namespace,
-			TargetPodName:       pod.Name,
-			TargetContainerPort: tunnel.LocalPort,
+			Config:          g.Config,
+			TargetNamespace: pod.Namespace,
+			TargetPodName:   pod.Name,
+			Ports: []portforwarder.PortPair{{
+				SourceHost:          tunnel.LocalHost,
+				SourcePort:          tunnel.LocalPort,
+				Listener:            listener,
+				TargetContainerPort: tunnel.PodPort(),
+				Observer:            observer,
+			}},
+			Drain:     drain,
+			Reconnect: reconnect,
+			Logger:    logger,
 		}
-		if err := f.PortForwarder.Run(po, nil, stopChan); err != nil {
-			return fmt.Errorf("could not start port-forwarder")
+		if pgo != nil {
+			po.Ready = func([]portforward.ForwardedPort) {
+				opened = make(chan struct{})
+				go func() {
+					defer close(opened)
+					recordPodEvent(ctx, g.clientset, pod, "TunnelOpened", message+" opened")
+				}()
+			}
 		}
-		klog.Info("stopped port-forwarder")
+		listener = nil
+		err := f.PortForwarder.Run(ctx, po)
+		if opened != nil {
+			<-opened
+			// ctx is already canceled when the tunnel is closed by the user
+			recordPodEvent(context.Background(), g.clientset, pod, "TunnelClosed", message+" closed")
+		}
+		if err != nil {
+			m.PortForwardError(tunnelName)
+			return fmt.Errorf("port-forwarder from %s to %s/%s:%d failed: %w", tunnelName, pod.Namespace, pod.Name, tunnel.PodPort(), err)
+		}
+		klog.Infof("stopped port-forwarder from %s", tunnelName)
 		return nil
+	}
+	eg.Go(func() error {
+		switch onFailure {
+		case ContinueOnTunnelFailure:
+			for {
+				err := run()
+				if err == nil {
+					return nil
+				}
+				klog.Infof("%s; continuing without the tunnel", err)
+				event.Emit(events, event.Event{Type: event.Error, Pod: podKey(pod), Context: g.Context, LocalAddr: tunnelName, Error: err.Error()})
+				if !waitForReconnect(ctx, reconnect) {
+					return nil
+				}
+			}
+		case RetryOnTunnelFailure:
+			b := backoff.NewExponentialBackOff()
+			b.MaxElapsedTime = 0
+			b.MaxInterval = 30 * time.Second
+			notify := func(err error, d time.Duration) {
+				klog.Infof("%s; retrying in %s", err, d.Round(time.Millisecond))
+				event.Emit(events, event.Event{Type: event.Error, Pod: podKey(pod), Context: g.Context, LocalAddr: tunnelName, Error: err.Error()})
+			}
+			retry := func() error {
+				err := run()
+				switch portforwarder.KindOf(err) {
+				case portforwarder.AuthError, portforwarder.PodGoneError:
+					// retry will not succeed
+					return backoff.Permanent(err)
+				}
+				return err
+			}
+			for {
+				b.Reset()
+				err := backoff.RetryNotify(retry, backoff.WithContext(b, ctx), notify)
+				if err == nil || ctx.Err() != nil {
+					return nil
+				}
+				if reconnect == nil {
+					return err
+				}
+				klog.Infof("%s; waiting for a request to reconnect", err)
+				event.Emit(events, event.Event{Type: event.Error, Pod: podKey(pod), Context: g.Context, LocalAddr: tunnelName, Error: err.Error()})
+				if !waitForReconnect(ctx, reconnect) {
+					return nil
+				}
+			}
+		default:
+			return run()
+		}
 	})
 }
+
+// adminTunnel returns the tunnel to the admin interface of Envoy in the pod.
+func adminTunnel(g *podGroup) tunnel.Tunnel {
+	return tunnel.Tunnel{LocalHost: "127.0.0.1", LocalPort: g.AdminLocalPort, ContainerPort: envoyAdminPort}
+}
+
+// waitForReconnect waits for a request to restart the failed tunnel.
+// It returns false if the context is done or there is no request channel.
+func waitForReconnect(ctx context.Context, reconnect <-chan struct{}) bool {
+	if reconnect == nil {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-reconnect:
+		klog.Infof("restarting the failed tunnel")
+		return true
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,334 @@
+package externalforwarder
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder/mock_portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	"golang.org/x/sync/errgroup"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeClientset returns a fake clientset which allows any access.
+// It names a created pod and sets the phase to it.
+func newFakeClientset(phase corev1.PodPhase) *fake.Clientset {
+	c := fake.NewSimpleClientset()
+	c.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = true
+		return true, review, nil
+	})
+	c.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Name = pod.GenerateName + "abcde"
+		pod.Status.Phase = phase
+		// fall through to the object tracker
+		return false, nil, nil
+	})
+	return c
+}
+
+// countActions returns the number of the actions of the verb to the resource.
+func countActions(c *fake.Clientset, verb, resource string) int {
+	var n int
+	for _, action := range c.Actions() {
+		if action.Matches(verb, resource) {
+			n++
+		}
+	}
+	return n
+}
+
+type emitterFunc func(e event.Event)
+
+func (f emitterFunc) Emit(e event.Event) { f(e) }
+
+func TestExternalForwarder_Do(t *testing.T) {
+	newOption := func() Option {
+		return Option{
+			Config:    &rest.Config{},
+			Namespace: "default",
+			Tunnels: []tunnel.Tunnel{
+				{LocalHost: "127.0.0.1", ContainerPort: 10000, RemoteHost: "db.example.com", RemotePort: 5432},
+			},
+			PodImage: "envoyproxy/envoy",
+		}
+	}
+	newExternalForwarder := func(pf portforwarder.Interface, c kubernetes.Interface) ExternalForwarder {
+		return ExternalForwarder{
+			PortForwarder: pf,
+			NewClientset: func(*rest.Config) (kubernetes.Interface, error) {
+				return c, nil
+			},
+			WhoAmI: func(context.Context, kubernetes.Interface) (string, error) {
+				return "alice@example.com", nil
+			},
+		}
+	}
+	assertPodDeleted := func(t *testing.T, c *fake.Clientset) {
+		t.Helper()
+		if n := countActions(c, "create", "pods"); n != 1 {
+			t.Errorf("pod creations want 1 but got %d", n)
+		}
+		pods, err := c.CoreV1().Pods("default").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("List error: %s", err)
+		}
+		if len(pods.Items) != 0 {
+			t.Errorf("pods want none but got %d", len(pods.Items))
+		}
+	}
+	// runAndCancel runs the external forwarder and cancels it when the tunnel is ready
+	runAndCancel := func(t *testing.T, f ExternalForwarder, ready <-chan struct{}) error {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			done <- f.Do(ctx, newOption())
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("Do returned before the tunnel is ready: %v", err)
+		case <-ready:
+		}
+		cancel()
+		return <-done
+	}
+	expectRun := func(pf *mock_portforwarder.MockInterface, ready chan struct{}) {
+		pf.EXPECT().Run(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, o portforwarder.Option) error {
+				if o.TargetNamespace != "default" || o.TargetPodName != "kubectl-external-forward-abcde" ||
+					len(o.Ports) != 1 || o.Ports[0].TargetContainerPort != 10000 {
+					t.Errorf("unexpected target %s/%s %+v", o.TargetNamespace, o.TargetPodName, o.Ports)
+				}
+				if o.Ready != nil {
+					o.Ready(nil)
+				}
+				close(ready)
+				<-ctx.Done()
+				return nil
+			})
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		c := newFakeClientset(corev1.PodRunning)
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		ready := make(chan struct{})
+		expectRun(pf, ready)
+
+		if err := runAndCancel(t, newExternalForwarder(pf, c), ready); err != nil {
+			t.Errorf("Do error: %s", err)
+		}
+		assertPodDeleted(t, c)
+	})
+
+	t.Run("PodFailed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		c := newFakeClientset(corev1.PodFailed)
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+
+		err := newExternalForwarder(pf, c).Do(context.TODO(), newOption())
+		if err == nil || !strings.Contains(err.Error(), "has Failed") {
+			t.Errorf("error wants pod failure but got %v", err)
+		}
+		assertPodDeleted(t, c)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		// the pod never becomes running
+		c := newFakeClientset(corev1.PodPending)
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		o := newOption()
+		o.Events = emitterFunc(func(e event.Event) {
+			if e.Type == event.PodCreated {
+				cancel()
+			}
+		})
+		if err := newExternalForwarder(pf, c).Do(ctx, o); err != nil {
+			t.Errorf("Do error: %s", err)
+		}
+		assertPodDeleted(t, c)
+	})
+
+	t.Run("CleanupRetry", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		c := newFakeClientset(corev1.PodRunning)
+		var deletions int
+		c.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			deletions++
+			if deletions == 1 {
+				return true, nil, apierrors.NewServiceUnavailable("temporary failure")
+			}
+			return false, nil, nil
+		})
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		ready := make(chan struct{})
+		expectRun(pf, ready)
+
+		if err := runAndCancel(t, newExternalForwarder(pf, c), ready); err != nil {
+			t.Errorf("Do error: %s", err)
+		}
+		if deletions != 2 {
+			t.Errorf("deletions want 2 but got %d", deletions)
+		}
+		assertPodDeleted(t, c)
+	})
+}
+
+func TestExternalForwarder_startPortForwarder(t *testing.T) {
+	g := &podGroup{
+		Namespace: "default",
+		pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kubectl-external-forward-abcde"}},
+	}
+	healthy := tunnel.Tunnel{LocalHost: "127.0.0.1", LocalPort: 10000, ContainerPort: 10000}
+	broken := tunnel.Tunnel{LocalHost: "127.0.0.1", LocalPort: 10001, ContainerPort: 10001}
+	errInUse := errors.New("address already in use")
+	runUntilStop := func(ctx context.Context, o portforwarder.Option) error {
+		if o.Ready != nil {
+			o.Ready(nil)
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	t.Run("Abort", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		healthyPF := mock_portforwarder.NewMockInterface(ctrl)
+		healthyPF.EXPECT().Run(gomock.Any(), gomock.Any()).DoAndReturn(runUntilStop)
+		brokenPF := mock_portforwarder.NewMockInterface(ctrl)
+		brokenPF.EXPECT().Run(gomock.Any(), gomock.Any()).Return(errInUse)
+
+		eg, ctx := errgroup.WithContext(context.TODO())
+		ExternalForwarder{PortForwarder: healthyPF}.startPortForwarder(ctx, eg, g, healthy, nil, AbortOnTunnelFailure)
+		ExternalForwarder{PortForwarder: brokenPF}.startPortForwarder(ctx, eg, g, broken, nil, AbortOnTunnelFailure)
+		err := eg.Wait()
+		if !errors.Is(err, errInUse) {
+			t.Errorf("error wants %s but got %v", errInUse, err)
+		}
+	})
+
+	t.Run("Continue", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		healthyPF := mock_portforwarder.NewMockInterface(ctrl)
+		healthyPF.EXPECT().Run(gomock.Any(), gomock.Any()).DoAndReturn(runUntilStop)
+		failed := make(chan struct{})
+		brokenPF := mock_portforwarder.NewMockInterface(ctrl)
+		brokenPF.EXPECT().Run(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, portforwarder.Option) error {
+			close(failed)
+			return errInUse
+		})
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		eg, ctx := errgroup.WithContext(ctx)
+		ExternalForwarder{PortForwarder: healthyPF}.startPortForwarder(ctx, eg, g, healthy, nil, ContinueOnTunnelFailure)
+		ExternalForwarder{PortForwarder: brokenPF}.startPortForwarder(ctx, eg, g, broken, nil, ContinueOnTunnelFailure)
+		<-failed
+		if ctx.Err() != nil {
+			t.Errorf("context wants alive but canceled")
+		}
+		cancel()
+		if err := eg.Wait(); err != nil {
+			t.Errorf("Wait error: %s", err)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		ready := make(chan struct{})
+		gomock.InOrder(
+			pf.EXPECT().Run(gomock.Any(), gomock.Any()).Return(errInUse),
+			pf.EXPECT().Run(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, o portforwarder.Option) error {
+				close(ready)
+				return runUntilStop(ctx, o)
+			}),
+		)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		eg, ctx := errgroup.WithContext(ctx)
+		ExternalForwarder{PortForwarder: pf}.startPortForwarder(ctx, eg, g, broken, nil, RetryOnTunnelFailure)
+		// the tunnel is ready at the second attempt
+		<-ready
+		cancel()
+		if err := eg.Wait(); err != nil {
+			t.Errorf("Wait error: %s", err)
+		}
+	})
+
+	t.Run("RetryPodGone", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		errPodGone := &portforwarder.Error{Kind: portforwarder.PodGoneError, Err: errors.New("pod not found")}
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		// it should not retry
+		pf.EXPECT().Run(gomock.Any(), gomock.Any()).Return(errPodGone)
+
+		eg, ctx := errgroup.WithContext(context.TODO())
+		ExternalForwarder{PortForwarder: pf}.startPortForwarder(ctx, eg, g, broken, nil, RetryOnTunnelFailure)
+		err := eg.Wait()
+		if portforwarder.KindOf(err) != portforwarder.PodGoneError {
+			t.Errorf("error wants %s but got %v", portforwarder.PodGoneError, err)
+		}
+	})
+
+	t.Run("ReconnectFailed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		ready := make(chan struct{})
+		gomock.InOrder(
+			pf.EXPECT().Run(gomock.Any(), gomock.Any()).Return(errInUse),
+			pf.EXPECT().Run(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, o portforwarder.Option) error {
+				close(ready)
+				<-ctx.Done()
+				return nil
+			}),
+		)
+		monitor := reconnectMonitor(make(chan struct{}, 1))
+		pgo := &podGroupOption{monitor: monitor}
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		eg, ctx := errgroup.WithContext(ctx)
+		ExternalForwarder{PortForwarder: pf}.startPortForwarder(ctx, eg, g, broken, pgo, ContinueOnTunnelFailure)
+		// the failed tunnel is restarted by the request
+		monitor <- struct{}{}
+		<-ready
+		cancel()
+		if err := eg.Wait(); err != nil {
+			t.Errorf("Wait error: %s", err)
+		}
+	})
+}
+
+// reconnectMonitor is a TunnelMonitor which sends the requests to reconnect from the channel.
+type reconnectMonitor chan struct{}
+
+func (m reconnectMonitor) Observer(tunnel.Tunnel) portforwarder.Observer { return nil }
+func (m reconnectMonitor) Reconnect(tunnel.Tunnel) <-chan struct{}       { return m }
@@ -0,0 +1,86 @@
+package externalforwarder
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/policy"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// podGroup represents a set of tunnels which share a proxy pod.
+type podGroup struct {
+	Context   string
+	Config    *rest.Config
+	Namespace string
+	Tunnels   []tunnel.Tunnel
+	// If set, forward the local port to the admin interface of Envoy
+	AdminLocalPort int
+
+	clientset kubernetes.Interface
+	policy    *policy.Policy
+	pod       *corev1.Pod
+	createdAt time.Time
+	audit     auditInfo
+	// listeners of the local addresses, bound before creating the pod
+	listeners map[string]net.Listener
+
+	deleteOnce sync.Once
+	deleteErr  error
+}
+
+func (g *podGroup) String() string {
+	if g.Context == "" {
+		return g.Namespace
+	}
+	return fmt.Sprintf("%s/%s", g.Context, g.Namespace)
+}
+
+// deletePod deletes the pod of the group.
+// It deletes the pod only once even if it is called more than once.
+func (g *podGroup) deletePod(events event.Emitter) error {
+	g.deleteOnce.Do(func() {
+		if g.pod == nil {
+			return
+		}
+		g.deleteErr = cleanupPod(g.clientset, g.pod, g.Context, events)
+	})
+	return g.deleteErr
+}
+
+// groupTunnels returns a podGroup for each pair of context and namespace.
+func groupTunnels(o Option, newClientset NewClientsetFunc) ([]*podGroup, error) {
+	var groups []*podGroup
+	index := make(map[[2]string]*podGroup)
+	for _, t := range o.Tunnels {
+		config, namespace := o.Config, o.Namespace
+		if t.Context != "" {
+			config = o.ContextConfigs[t.Context]
+			if config == nil {
+				return nil, fmt.Errorf("no config for context %s", t.Context)
+			}
+		}
+		if t.Namespace != "" {
+			namespace = t.Namespace
+		}
+		key := [2]string{t.Context, namespace}
+		g := index[key]
+		if g == nil {
+			clientset, err := newClientset(config)
+			if err != nil {
+				return nil, fmt.Errorf("could not create a client set: %w", err)
+			}
+			g = &podGroup{Context: t.Context, Config: config, Namespace: namespace, clientset: clientset}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.Tunnels = append(g.Tunnels, t)
+	}
+	return groups, nil
+}
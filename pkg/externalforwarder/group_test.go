@@ -0,0 +1,190 @@
+package externalforwarder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder/mock_portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPodGroup_deletePod(t *testing.T) {
+	newGroup := func(t *testing.T, c *fake.Clientset) *podGroup {
+		pod, err := c.CoreV1().Pods("default").Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "kubectl-external-forward-"},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Create error: %s", err)
+		}
+		return &podGroup{Context: "staging", Namespace: "default", clientset: c, pod: pod}
+	}
+
+	t.Run("NoPod", func(t *testing.T) {
+		var g podGroup
+		if err := g.deletePod(nil); err != nil {
+			t.Errorf("deletePod error: %s", err)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		c := newFakeClientset(corev1.PodRunning)
+		g := newGroup(t, c)
+		var deletions int
+		c.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			deletions++
+			if deletions <= 2 {
+				return true, nil, apierrors.NewServiceUnavailable("temporary failure")
+			}
+			return false, nil, nil
+		})
+		var deleted []string
+		events := emitterFunc(func(e event.Event) {
+			if e.Type == event.PodDeleted {
+				deleted = append(deleted, e.Context+":"+e.Pod)
+			}
+		})
+		if err := g.deletePod(events); err != nil {
+			t.Fatalf("deletePod error: %s", err)
+		}
+		if deletions != 3 {
+			t.Errorf("deletions want 3 but got %d", deletions)
+		}
+		if len(deleted) != 1 || deleted[0] != "staging:default/kubectl-external-forward-abcde" {
+			t.Errorf("events want the deleted pod but got %v", deleted)
+		}
+		if _, err := c.CoreV1().Pods("default").Get(context.TODO(), g.pod.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("pod wants deleted but got %v", err)
+		}
+	})
+
+	t.Run("Once", func(t *testing.T) {
+		c := newFakeClientset(corev1.PodRunning)
+		g := newGroup(t, c)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := g.deletePod(nil); err != nil {
+					t.Errorf("deletePod error: %s", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := countActions(c, "delete", "pods"); n != 1 {
+			t.Errorf("deletions want 1 but got %d", n)
+		}
+	})
+}
+
+func TestExternalForwarder_Do_groupFailure(t *testing.T) {
+	// a tunnel of each namespace, and the tunnel of staging fails
+	o := Option{
+		Config:    &rest.Config{},
+		Namespace: "default",
+		Tunnels: []tunnel.Tunnel{
+			{LocalHost: "127.0.0.1", ContainerPort: 10000, RemoteHost: "db.example.com", RemotePort: 5432},
+			{LocalHost: "127.0.0.1", ContainerPort: 10000, RemoteHost: "api.example.com", RemotePort: 443, Namespace: "staging"},
+		},
+		PodImage: "envoyproxy/envoy",
+	}
+	errInUse := errors.New("address already in use")
+	newExternalForwarder := func(pf portforwarder.Interface, c kubernetes.Interface) ExternalForwarder {
+		return ExternalForwarder{
+			PortForwarder: pf,
+			NewClientset: func(*rest.Config) (kubernetes.Interface, error) {
+				return c, nil
+			},
+			WhoAmI: func(context.Context, kubernetes.Interface) (string, error) {
+				return "alice@example.com", nil
+			},
+		}
+	}
+	assertPodsDeleted := func(t *testing.T, c *fake.Clientset) {
+		t.Helper()
+		if n := countActions(c, "delete", "pods"); n != 2 {
+			t.Errorf("deletions want 2 but got %d", n)
+		}
+		for _, namespace := range []string{"default", "staging"} {
+			pods, err := c.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("List error: %s", err)
+			}
+			if len(pods.Items) != 0 {
+				t.Errorf("pods in %s want none but got %d", namespace, len(pods.Items))
+			}
+		}
+	}
+
+	t.Run("Abort", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		c := newFakeClientset(corev1.PodRunning)
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		// the tunnel of default may not start before the abort
+		pf.EXPECT().Run(gomock.Any(), gomock.Any()).MinTimes(1).MaxTimes(2).
+			DoAndReturn(func(ctx context.Context, po portforwarder.Option) error {
+				if po.TargetNamespace == "staging" {
+					return errInUse
+				}
+				<-ctx.Done()
+				return nil
+			})
+
+		o := o
+		o.OnTunnelFailure = AbortOnTunnelFailure
+		err := newExternalForwarder(pf, c).Do(context.TODO(), o)
+		if !errors.Is(err, errInUse) {
+			t.Errorf("error wants %s but got %v", errInUse, err)
+		}
+		assertPodsDeleted(t, c)
+	})
+
+	t.Run("Continue", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		c := newFakeClientset(corev1.PodRunning)
+		pf := mock_portforwarder.NewMockInterface(ctrl)
+		failed, running := make(chan struct{}), make(chan struct{})
+		pf.EXPECT().Run(gomock.Any(), gomock.Any()).Times(2).
+			DoAndReturn(func(ctx context.Context, po portforwarder.Option) error {
+				if po.TargetNamespace == "staging" {
+					close(failed)
+					return errInUse
+				}
+				close(running)
+				<-ctx.Done()
+				return nil
+			})
+
+		o := o
+		o.OnTunnelFailure = ContinueOnTunnelFailure
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			done <- newExternalForwarder(pf, c).Do(ctx, o)
+		}()
+		<-failed
+		<-running
+		cancel()
+		// the failure of staging should not stop default
+		if err := <-done; err != nil {
+			t.Errorf("Do error: %s", err)
+		}
+		assertPodsDeleted(t, c)
+	})
+}
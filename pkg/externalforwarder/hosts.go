@@ -0,0 +1,72 @@
+package externalforwarder
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/int128/kubectl-external-forward/pkg/hostsfile"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	"k8s.io/klog/v2"
+)
+
+// addHostsFileEntries writes the remote hostnames into the hosts file.
+// It is called before creating the pods, so that a permission error stops before any API call.
+// It returns a function to remove the entries.
+func addHostsFileEntries(path string, tunnels []tunnel.Tunnel) (func() error, error) {
+	id := strconv.Itoa(os.Getpid())
+	var entries []hostsfile.Entry
+	for hostname, ip := range hostRecords(tunnels) {
+		entries = append(entries, hostsfile.Entry{IP: ip.String(), Hostname: hostname})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Hostname < entries[j].Hostname })
+	removed, err := hostsfile.RemoveStale(path, func(id string) bool {
+		pid, err := strconv.Atoi(id)
+		return err != nil || processExists(pid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not clean up the hosts file: %w", err)
+	}
+	for _, id := range removed {
+		klog.Infof("removed the stale entries of PID %s from %s", id, path)
+	}
+	klog.Infof("adding %d entries to %s", len(entries), path)
+	if err := hostsfile.Add(path, id, entries); err != nil {
+		return nil, fmt.Errorf("could not update the hosts file: %w", err)
+	}
+	return func() error {
+		klog.Infof("removing the entries from %s", path)
+		if err := hostsfile.Remove(path, id); err != nil {
+			return fmt.Errorf("you need to remove the entries from %s manually: %w", path, err)
+		}
+		return nil
+	}, nil
+}
+
+// hostRecords returns the map of remote hostname to local address.
+// It contains all upstream hosts of a tunnel.
+// A Kubernetes service, SRV record or IP address is not a hostname to resolve, hence it is skipped.
+func hostRecords(tunnels []tunnel.Tunnel) map[string]net.IP {
+	records := make(map[string]net.IP)
+	for _, t := range tunnels {
+		if _, _, ok := t.ServiceRef(); ok {
+			continue
+		}
+		if t.IsSRV() {
+			continue
+		}
+		ip := net.ParseIP(t.LocalHost)
+		if ip == nil || ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		for _, e := range t.Upstreams() {
+			if net.ParseIP(e.Host) != nil {
+				continue
+			}
+			records[e.Host] = ip
+		}
+	}
+	return records
+}
@@ -0,0 +1,72 @@
+package externalforwarder
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+func TestHostRecords(t *testing.T) {
+	got := hostRecords([]tunnel.Tunnel{
+		{LocalHost: "127.0.0.2", RemoteHost: "db.example.com", RemotePort: 5432},
+		{LocalHost: "0.0.0.0", RemoteHost: "cache-1.example.com", RemotePort: 6379, Endpoints: []tunnel.Endpoint{
+			{Host: "cache-1.example.com", Port: 6379},
+			{Host: "cache-2.example.com", Port: 6379},
+			{Host: "10.0.0.3", Port: 6379},
+		}},
+		{LocalHost: "127.0.0.1", RemoteHost: "_postgres._tcp.db.example.com"},
+		{LocalHost: "127.0.0.1", RemoteHost: "svc/db.default"},
+		{LocalHost: "127.0.0.1", RemoteHost: "10.0.0.1", RemotePort: 80},
+	})
+	want := map[string]net.IP{
+		"db.example.com":      net.IPv4(127, 0, 0, 2),
+		"cache-1.example.com": net.IPv4(127, 0, 0, 1),
+		"cache-2.example.com": net.IPv4(127, 0, 0, 1),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAddHostsFileEntries(t *testing.T) {
+	t.Run("Writable", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hosts")
+		if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+			t.Fatalf("WriteFile error: %s", err)
+		}
+		remove, err := addHostsFileEntries(path, []tunnel.Tunnel{{LocalHost: "127.0.0.1", RemoteHost: "db.example.com"}})
+		if err != nil {
+			t.Fatalf("addHostsFileEntries error: %s", err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile error: %s", err)
+		}
+		if !strings.Contains(string(b), "127.0.0.1\tdb.example.com") {
+			t.Errorf("hosts file wants the entry but got %s", b)
+		}
+		if err := remove(); err != nil {
+			t.Fatalf("remove error: %s", err)
+		}
+		b, err = os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile error: %s", err)
+		}
+		if diff := cmp.Diff("127.0.0.1 localhost\n", string(b)); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("NotWritable", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "no-such-dir", "hosts")
+		_, err := addHostsFileEntries(path, []tunnel.Tunnel{{LocalHost: "127.0.0.1", RemoteHost: "db.example.com"}})
+		if err == nil {
+			t.Errorf("addHostsFileEntries wants an error but got nil")
+		}
+	})
+}
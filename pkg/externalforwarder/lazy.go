@@ -0,0 +1,273 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"k8s.io/klog/v2"
+)
+
+// LazyOption represents an option of the lazy mode.
+type LazyOption struct {
+	// Delete the pod when no connection is active for the duration
+	IdleTimeout time.Duration
+}
+
+// lazyPodGroup creates the pod of a group on the first connection,
+// and deletes the pod when no connection is active for the idle timeout.
+// It accepts the connections on the listeners of the group,
+// and hands them to the port-forwarders via the channel listeners.
+type lazyPodGroup struct {
+	f           ExternalForwarder
+	group       *podGroup
+	po          podOption
+	pgo         podGroupOption
+	idleTimeout time.Duration
+
+	mu          sync.Mutex
+	active      *lazyActivation
+	connections int
+	idleTimer   *time.Timer
+	// incremented for each idle timer, to ignore a timer which has been replaced
+	idleGeneration int
+	stopped        bool
+	// wait for the pods to be deleted on exit
+	wg sync.WaitGroup
+}
+
+// lazyActivation represents a lifecycle of the pod.
+type lazyActivation struct {
+	listeners map[string]*chanListener
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func (f ExternalForwarder) startLazyPodGroup(ctx context.Context, eg *errgroup.Group, g *podGroup, po podOption, pgo podGroupOption, lo LazyOption) {
+	lg := &lazyPodGroup{f: f, group: g, po: po, pgo: pgo, idleTimeout: lo.IdleTimeout}
+	klog.Infof("waiting for a connection to create a pod in %s", g)
+	for addr, l := range g.listeners {
+		addr, l := addr, l
+		eg.Go(func() error {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					if ctx.Err() != nil || isClosed(pgo.drainChan) {
+						return nil
+					}
+					return err
+				}
+				lg.handleConnection(ctx, addr, conn)
+			}
+		})
+	}
+	eg.Go(func() error {
+		// stop accepting on drain or cancel
+		select {
+		case <-ctx.Done():
+		case <-pgo.drainChan:
+		}
+		for _, l := range g.listeners {
+			_ = l.Close()
+		}
+		<-ctx.Done()
+		lg.mu.Lock()
+		lg.stopped = true
+		if lg.idleTimer != nil {
+			lg.idleTimer.Stop()
+		}
+		lg.mu.Unlock()
+		// the pod is deleted by the cancel of the parent context
+		lg.wg.Wait()
+		return nil
+	})
+}
+
+// handleConnection hands the connection to the port-forwarder.
+// If no pod is running, it creates a pod and holds the connection until the port-forwarder is ready.
+func (lg *lazyPodGroup) handleConnection(ctx context.Context, addr string, conn net.Conn) {
+	lg.mu.Lock()
+	if lg.stopped {
+		lg.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	lg.connections++
+	if lg.idleTimer != nil {
+		lg.idleTimer.Stop()
+		lg.idleTimer = nil
+	}
+	if lg.active == nil {
+		lg.active = lg.activate(ctx)
+	}
+	l := lg.active.listeners[addr]
+	lg.mu.Unlock()
+
+	// track the connection from now, because the port-forwarder accepts it after the pod is created
+	client := conn.RemoteAddr()
+	tracker := lg.pgo.connections.Observer(addr, lg.remote(addr))
+	if tracker != nil {
+		tracker.ConnectionOpened(client)
+	}
+	onClose := func() {
+		if tracker != nil {
+			tracker.ConnectionClosed(client)
+		}
+		lg.connectionClosed()
+	}
+	go l.deliver(&trackedConn{Conn: conn, onClose: onClose})
+}
+
+// remote returns the remote address of the tunnel of the local address.
+func (lg *lazyPodGroup) remote(addr string) string {
+	for _, t := range lg.group.Tunnels {
+		if localAddr(t) == addr {
+			return fmt.Sprintf("%s:%d", t.RemoteHost, t.RemotePort)
+		}
+	}
+	return ""
+}
+
+func (lg *lazyPodGroup) connectionClosed() {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	lg.connections--
+	if lg.connections > 0 || lg.active == nil || lg.stopped {
+		return
+	}
+	lg.idleGeneration++
+	generation := lg.idleGeneration
+	lg.idleTimer = time.AfterFunc(lg.idleTimeout, func() { lg.idle(generation) })
+}
+
+// idle deletes the pod if no connection has been made since the timer started.
+func (lg *lazyPodGroup) idle(generation int) {
+	lg.mu.Lock()
+	if lg.idleTimer == nil || lg.idleGeneration != generation || lg.connections > 0 || lg.active == nil {
+		lg.mu.Unlock()
+		return
+	}
+	a := lg.active
+	lg.active = nil
+	lg.idleTimer = nil
+	lg.mu.Unlock()
+
+	klog.Infof("no connection in %s for %s, deleting the pod", lg.group, lg.idleTimeout)
+	a.cancel()
+	<-a.done
+}
+
+// activate starts a lifecycle of the pod in background.
+// It must be called with the lock held.
+func (lg *lazyPodGroup) activate(ctx context.Context) *lazyActivation {
+	ctx, cancel := context.WithCancel(ctx)
+	a := &lazyActivation{
+		listeners: make(map[string]*chanListener),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	for addr, l := range lg.group.listeners {
+		a.listeners[addr] = newChanListener(l.Addr())
+	}
+	lg.wg.Add(1)
+	go func() {
+		defer lg.wg.Done()
+		defer close(a.done)
+		defer cancel()
+		if err := lg.runPod(ctx, a); err != nil {
+			klog.Infof("pod in %s has been stopped: %s", lg.group, err)
+		}
+		// close the connections waiting for the port-forwarders
+		for _, l := range a.listeners {
+			_ = l.Close()
+		}
+		lg.mu.Lock()
+		if lg.active == a {
+			lg.active = nil
+		}
+		lg.mu.Unlock()
+	}()
+	return a
+}
+
+// runPod creates a pod and runs the port-forwarders until the context is canceled.
+func (lg *lazyPodGroup) runPod(ctx context.Context, a *lazyActivation) error {
+	g := &podGroup{
+		Context:        lg.group.Context,
+		Config:         lg.group.Config,
+		Namespace:      lg.group.Namespace,
+		Tunnels:        lg.group.Tunnels,
+		AdminLocalPort: lg.group.AdminLocalPort,
+		clientset:      lg.group.clientset,
+		policy:         lg.group.policy,
+		listeners:      make(map[string]net.Listener),
+	}
+	for addr, l := range a.listeners {
+		g.listeners[addr] = l
+	}
+	if err := createPod(ctx, g, lg.po, lg.pgo.events); err != nil {
+		return err
+	}
+	// the connections are tracked by handleConnection
+	pgo := lg.pgo
+	pgo.connections = nil
+	eg, ctx := errgroup.WithContext(ctx)
+	lg.f.startPodGroup(ctx, eg, g, pgo)
+	return eg.Wait()
+}
+
+// chanListener is a net.Listener which accepts the connections delivered via a channel.
+type chanListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newChanListener(addr net.Addr) *chanListener {
+	return &chanListener{addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *chanListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr {
+	return l.addr
+}
+
+// deliver blocks until the connection is accepted.
+// If the listener is closed, it closes the connection.
+func (l *chanListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		_ = conn.Close()
+	}
+}
+
+// trackedConn calls onClose when the connection is closed for the first time.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.onClose)
+	return err
+}
@@ -0,0 +1,233 @@
+package externalforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/fakeapiserver"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder/mock_portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestExternalForwarder_Do_lazy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	o := Option{
+		Config:    &rest.Config{},
+		Namespace: "default",
+		Tunnels: []tunnel.Tunnel{
+			{LocalHost: "127.0.0.1", LocalPort: port, ContainerPort: 10000, RemoteHost: "db.example.com", RemotePort: 5432},
+		},
+		PodImage:        "envoyproxy/envoy",
+		Lazy:            &LazyOption{IdleTimeout: 100 * time.Millisecond},
+		IgnoreInterrupt: true,
+	}
+	countPods := func(t *testing.T, c *fake.Clientset) int {
+		t.Helper()
+		pods, err := c.CoreV1().Pods("default").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("List error: %s", err)
+		}
+		return len(pods.Items)
+	}
+	waitForPods := func(t *testing.T, c *fake.Clientset, want int) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if countPods(t, c) == want {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("pods want %d but got %d", want, countPods(t, c))
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c := newFakeClientset(corev1.PodRunning)
+	pf := mock_portforwarder.NewMockInterface(ctrl)
+	// the port-forwarder echoes the connections until the pod is deleted
+	pf.EXPECT().Run(gomock.Any(), gomock.Any()).Times(2).
+		DoAndReturn(func(ctx context.Context, po portforwarder.Option) error {
+			l := po.Ports[0].Listener
+			go func() {
+				<-ctx.Done()
+				_ = l.Close()
+			}()
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return nil
+				}
+				go func() {
+					defer conn.Close()
+					_, _ = io.Copy(conn, conn)
+				}()
+			}
+		})
+	f := ExternalForwarder{
+		PortForwarder: pf,
+		NewClientset: func(*rest.Config) (kubernetes.Interface, error) {
+			return c, nil
+		},
+		WhoAmI: func(context.Context, kubernetes.Interface) (string, error) {
+			return "alice@example.com", nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Do(ctx, o)
+	}()
+
+	echo := func(t *testing.T) {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			got, err := fakeapiserver.Echo(addr, "hello")
+			if err == nil && got == "hello" {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("could not echo via %s", addr)
+	}
+	// the pod is created on the first connection
+	echo(t)
+	if n := countActions(c, "create", "pods"); n != 1 {
+		t.Errorf("pod creations want 1 but got %d", n)
+	}
+	// the pod is deleted when idle
+	waitForPods(t, c, 0)
+	// the next connection creates a pod again
+	echo(t)
+	if n := countActions(c, "create", "pods"); n != 2 {
+		t.Errorf("pod creations want 2 but got %d", n)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Do error: %s", err)
+	}
+	if n := countPods(t, c); n != 0 {
+		t.Errorf("pods want none but got %d", n)
+	}
+
+	t.Run("OnTunnelFailure", func(t *testing.T) {
+		o := o
+		o.OnTunnelFailure = RetryOnTunnelFailure
+		if err := f.Do(context.TODO(), o); err == nil {
+			t.Errorf("Do wants error but got nil")
+		}
+	})
+}
+
+func TestLazyPodGroup_handleConnection(t *testing.T) {
+	// the pod never becomes running, so the connection waits for the port-forwarder
+	c := newFakeClientset(corev1.PodPending)
+	tracker := newConnectionTracker()
+	g := &podGroup{
+		Config:    &rest.Config{},
+		Namespace: "default",
+		Tunnels: []tunnel.Tunnel{
+			{LocalHost: "127.0.0.1", LocalPort: 15432, ContainerPort: 10000, RemoteHost: "db.example.com", RemotePort: 5432},
+		},
+		clientset: c,
+		listeners: map[string]net.Listener{
+			"127.0.0.1:15432": newChanListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 15432}),
+		},
+	}
+	whoAmI := func(context.Context, kubernetes.Interface) (string, error) {
+		return "alice@example.com", nil
+	}
+	lg := &lazyPodGroup{
+		group:       g,
+		po:          podOption{image: "envoyproxy/envoy", whoAmI: whoAmI},
+		pgo:         podGroupOption{connections: tracker},
+		idleTimeout: time.Minute,
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	lg.handleConnection(ctx, "127.0.0.1:15432", server)
+	want := []string{"pipe -> 127.0.0.1:15432 -> db.example.com:5432"}
+	if diff := cmp.Diff(want, tracker.active()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// the waiting connection is closed when the pod is stopped
+	cancel()
+	lg.wg.Wait()
+	for i := 0; i < 50 && len(tracker.active()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if active := tracker.active(); len(active) != 0 {
+		t.Errorf("active connections want none but got %v", active)
+	}
+}
+
+func TestChanListener(t *testing.T) {
+	t.Run("Deliver", func(t *testing.T) {
+		l := newChanListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000})
+		client, server := net.Pipe()
+		defer client.Close()
+		go l.deliver(server)
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept error: %s", err)
+		}
+		if conn != server {
+			t.Errorf("Accept wants the delivered connection but got %v", conn)
+		}
+		_ = conn.Close()
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		l := newChanListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000})
+		if err := l.Close(); err != nil {
+			t.Fatalf("Close error: %s", err)
+		}
+		if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept error wants %s but got %v", net.ErrClosed, err)
+		}
+		// a waiting connection should be closed
+		client, server := net.Pipe()
+		var closed int
+		l.deliver(&trackedConn{Conn: server, onClose: func() { closed++ }})
+		if closed != 1 {
+			t.Errorf("onClose wants 1 call but got %d", closed)
+		}
+		if _, err := client.Write([]byte("x")); err == nil {
+			t.Errorf("Write wants an error after close")
+		}
+	})
+}
+
+func TestTrackedConn(t *testing.T) {
+	_, server := net.Pipe()
+	var closed int
+	c := &trackedConn{Conn: server, onClose: func() { closed++ }}
+	_ = c.Close()
+	_ = c.Close()
+	if closed != 1 {
+		t.Errorf("onClose wants 1 call but got %d", closed)
+	}
+}
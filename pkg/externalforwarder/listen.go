@@ -0,0 +1,81 @@
+package externalforwarder
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+// localAddr returns the local address of the tunnel.
+func localAddr(t tunnel.Tunnel) string {
+	return net.JoinHostPort(t.LocalHost, strconv.Itoa(t.LocalPort))
+}
+
+// listenGroups binds the local ports of the tunnels and admin interfaces before creating the pods.
+// The listeners are handed to the port-forwarders.
+// If the local port of a tunnel is zero, it is set to the port allocated by the kernel.
+// If any port is not available, it closes the bound listeners and returns an error.
+func listenGroups(groups []*podGroup) error {
+	for _, g := range groups {
+		g.listeners = make(map[string]net.Listener)
+		for i, t := range g.Tunnels {
+			l, err := listenLocal(localAddr(t))
+			if err != nil {
+				closeListeners(groups)
+				return err
+			}
+			if t.LocalPort == 0 {
+				g.Tunnels[i].LocalPort = l.Addr().(*net.TCPAddr).Port
+			}
+			g.listeners[localAddr(g.Tunnels[i])] = l
+		}
+		if g.AdminLocalPort != 0 {
+			addr := localAddr(adminTunnel(g))
+			l, err := listenLocal(addr)
+			if err != nil {
+				closeListeners(groups)
+				return err
+			}
+			g.listeners[addr] = l
+		}
+	}
+	return nil
+}
+
+// closeListeners closes the listeners which are not handed to the port-forwarders.
+func closeListeners(groups []*podGroup) {
+	for _, g := range groups {
+		for _, l := range g.listeners {
+			_ = l.Close()
+		}
+	}
+}
+
+// listenLocal listens on the address.
+// If the port is in use, it returns an error with the process which owns the port if possible.
+func listenLocal(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err == nil {
+		return l, nil
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		_, port, _ := net.SplitHostPort(addr)
+		if p, perr := strconv.Atoi(port); perr == nil {
+			if pid, name, ok := findPortOwner(p); ok {
+				return nil, fmt.Errorf("%s is in use by PID %d (%s)", addr, pid, name)
+			}
+		}
+		return nil, fmt.Errorf("%s is in use", addr)
+	}
+	if errors.Is(err, syscall.EADDRNOTAVAIL) && runtime.GOOS == "darwin" {
+		// macOS has only 127.0.0.1 on the loopback interface by default
+		host, _, _ := net.SplitHostPort(addr)
+		return nil, fmt.Errorf("could not listen on %s: %w (run sudo ifconfig lo0 alias %s)", addr, err, host)
+	}
+	return nil, fmt.Errorf("could not listen on %s: %w", addr, err)
+}
@@ -0,0 +1,81 @@
+package externalforwarder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tcpListenState is the state of a listening socket in /proc/net/tcp.
+const tcpListenState = "0A"
+
+// findPortOwner returns the process which is listening on the TCP port.
+// It looks up the inode of the socket in /proc/net/tcp and /proc/net/tcp6,
+// and then finds the process which has the socket in /proc/*/fd.
+// It may not find the process of another user.
+func findPortOwner(port int) (int, string, bool) {
+	inodes := make(map[string]bool)
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for _, inode := range listeningSocketInodes(name, port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, "", false
+	}
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return 0, "", false
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(link, "socket:[") || !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		// fd is /proc/PID/fd/N
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(fd))))
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		if err != nil {
+			return pid, "unknown", true
+		}
+		return pid, strings.TrimSpace(string(comm)), true
+	}
+	return 0, "", false
+}
+
+// listeningSocketInodes returns the inodes of the listening sockets on the port.
+func listeningSocketInodes(name string, port int) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var inodes []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 || fields[3] != tcpListenState {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			continue
+		}
+		p, err := strconv.ParseInt(fields[1][i+1:], 16, 32)
+		if err != nil || int(p) != port {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
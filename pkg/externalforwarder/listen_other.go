@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package externalforwarder
+
+// findPortOwner is not supported on this platform.
+func findPortOwner(int) (int, string, bool) {
+	return 0, "", false
+}
@@ -0,0 +1,87 @@
+package externalforwarder
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+func TestListenGroups(t *testing.T) {
+	t.Run("Available", func(t *testing.T) {
+		port := freePort(t)
+		groups := []*podGroup{{Tunnels: []tunnel.Tunnel{{LocalHost: "127.0.0.1", LocalPort: port}}}}
+		if err := listenGroups(groups); err != nil {
+			t.Fatalf("listenGroups error: %s", err)
+		}
+		defer closeListeners(groups)
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+		if groups[0].listeners[addr] == nil {
+			t.Errorf("listeners wants %s but got %v", addr, groups[0].listeners)
+		}
+	})
+
+	t.Run("AllocatePort", func(t *testing.T) {
+		groups := []*podGroup{{Tunnels: []tunnel.Tunnel{{LocalHost: "127.0.0.1"}, {LocalHost: "127.0.0.1"}}}}
+		if err := listenGroups(groups); err != nil {
+			t.Fatalf("listenGroups error: %s", err)
+		}
+		defer closeListeners(groups)
+		for i, tunnel := range groups[0].Tunnels {
+			if tunnel.LocalPort == 0 {
+				t.Errorf("local port %d wants allocated but got 0", i)
+			}
+			if groups[0].listeners[localAddr(tunnel)] == nil {
+				t.Errorf("listeners wants %s but got %v", localAddr(tunnel), groups[0].listeners)
+			}
+		}
+		if len(groups[0].listeners) != 2 {
+			t.Errorf("listeners want 2 but got %v", groups[0].listeners)
+		}
+	})
+
+	t.Run("InUse", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %s", err)
+		}
+		defer l.Close()
+		available := freePort(t)
+		inUse := l.Addr().(*net.TCPAddr).Port
+		groups := []*podGroup{
+			{Tunnels: []tunnel.Tunnel{{LocalHost: "127.0.0.1", LocalPort: available}}},
+			{Tunnels: []tunnel.Tunnel{{LocalHost: "127.0.0.1", LocalPort: inUse}}},
+		}
+		err = listenGroups(groups)
+		if err == nil {
+			t.Fatalf("listenGroups wants an error but got nil")
+		}
+		want := fmt.Sprintf("127.0.0.1:%d is in use", inUse)
+		if runtime.GOOS == "linux" {
+			want = fmt.Sprintf("127.0.0.1:%d is in use by PID %d (", inUse, os.Getpid())
+		}
+		if !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("error wants %s but got %s", want, err)
+		}
+		// the other listener should be closed
+		a, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", available))
+		if err != nil {
+			t.Errorf("port %d wants closed but got %s", available, err)
+			return
+		}
+		a.Close()
+	})
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,32 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/int128/kubectl-external-forward/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// envoyStatsFetcher returns a function to get the stats of Envoy via port-forwarding.
+func (f ExternalForwarder) envoyStatsFetcher(config *rest.Config, pod *corev1.Pod) metrics.FetchFunc {
+	client := f.envoyAdminHTTPClient(config, pod)
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, envoyAdminBaseURL+"/stats/prometheus", nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create a request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the stats: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("could not get the stats: status %s", resp.Status)
+		}
+		return resp.Body, nil
+	}
+}
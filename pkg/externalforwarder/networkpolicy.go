@@ -0,0 +1,225 @@
+package externalforwarder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// instanceLabelKey is the label to select a pod by the network policy.
+const instanceLabelKey = "kubectl-external-forward/instance"
+
+// namespaceNameLabelKey is the label of the name set to every namespace.
+const namespaceNameLabelKey = "kubernetes.io/metadata.name"
+
+// clusterDNSNamespace and clusterDNSPodLabels select the pods of the cluster DNS,
+// which are the same in kube-dns and CoreDNS.
+var (
+	clusterDNSNamespace = "kube-system"
+	clusterDNSPodLabels = map[string]string{"k8s-app": "kube-dns"}
+)
+
+func newInstanceID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate an instance ID: %w", err)
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// lookupCIDRFunc returns the CIDRs of a host as seen from the pod.
+type lookupCIDRFunc func(ctx context.Context, host string) ([]string, error)
+
+// newClusterLookupCIDR returns a function which determines the CIDRs of a host.
+// The local resolver is not used, because it may return other addresses than the cluster DNS,
+// such as a split-horizon or private name.
+//
+// A host in egressCIDRs is resolved to the given CIDRs.
+// A service name of the cluster is resolved to the addresses of the endpoints via the API.
+// Any other host is an error, because the addresses are not known before the pod runs.
+func newClusterLookupCIDR(c kubernetes.Interface, egressCIDRs map[string][]string) lookupCIDRFunc {
+	var lookup lookupCIDRFunc
+	lookup = func(ctx context.Context, host string) ([]string, error) {
+		if cidrs, ok := egressCIDRs[host]; ok {
+			return cidrs, nil
+		}
+		name, namespace, ok := clusterServiceRef(host)
+		if !ok {
+			return nil, fmt.Errorf("the addresses of %s in the cluster are unknown, give the CIDRs explicitly", host)
+		}
+		svc, err := c.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not get the service: %w", err)
+		}
+		if svc.Spec.Type == corev1.ServiceTypeExternalName {
+			return lookup(ctx, svc.Spec.ExternalName)
+		}
+		// a network policy applies to the addresses of the pods, not the cluster IP
+		ep, err := c.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not get the endpoints: %w", err)
+		}
+		var cidrs []string
+		for _, subset := range ep.Subsets {
+			for _, addr := range subset.Addresses {
+				if ip := net.ParseIP(addr.IP); ip != nil {
+					cidrs = append(cidrs, hostCIDR(ip))
+				}
+			}
+		}
+		if len(cidrs) == 0 {
+			return nil, fmt.Errorf("no ready endpoint of the service")
+		}
+		return cidrs, nil
+	}
+	return lookup
+}
+
+// clusterServiceRef returns the name and namespace of the service
+// if the host is in form of NAME.NAMESPACE.svc or NAME.NAMESPACE.svc.CLUSTER_DOMAIN.
+func clusterServiceRef(host string) (name, namespace string, ok bool) {
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) < 3 || labels[2] != "svc" {
+		return "", "", false
+	}
+	return labels[0], labels[1], true
+}
+
+// createNetworkPolicy creates a network policy which allows the pods of the instance
+// to connect only to the destinations and DNS.
+// It must be created before the pod, so that the pod never runs without the network policy.
+func createNetworkPolicy(ctx context.Context, c kubernetes.Interface, namespace, instanceID string, tunnels []tunnel.Tunnel, po podOption) (*networkingv1.NetworkPolicy, error) {
+	np, err := newEgressNetworkPolicy(ctx, newClusterLookupCIDR(c, po.egressCIDRs), namespace, instanceID, tunnels, po.allowUnresolved)
+	if err != nil {
+		return nil, err
+	}
+	np, err = c.NetworkingV1().NetworkPolicies(namespace).Create(ctx, np, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create network policy: %w", err)
+	}
+	klog.Infof("created network policy %s/%s", np.Namespace, np.Name)
+	return np, nil
+}
+
+// setNetworkPolicyOwner sets the pod to the owner of the network policy,
+// so that it is deleted by the garbage collector when the pod is deleted.
+func setNetworkPolicyOwner(ctx context.Context, c kubernetes.Interface, np *networkingv1.NetworkPolicy, pod *corev1.Pod) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       pod.Name,
+					UID:        pod.UID,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode the patch: %w", err)
+	}
+	_, err = c.NetworkingV1().NetworkPolicies(np.Namespace).Patch(ctx, np.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("could not set the owner of network policy %s/%s: %w", np.Namespace, np.Name, err)
+	}
+	return nil
+}
+
+// deleteNetworkPolicy deletes the network policy which is not owned by any pod.
+func deleteNetworkPolicy(c kubernetes.Interface, np *networkingv1.NetworkPolicy) {
+	err := c.NetworkingV1().NetworkPolicies(np.Namespace).Delete(context.Background(), np.Name, metav1.DeleteOptions{})
+	if err != nil {
+		klog.Infof("you need to delete network policy %s/%s manually: %s", np.Namespace, np.Name, err)
+		return
+	}
+	klog.Infof("deleted network policy %s/%s", np.Namespace, np.Name)
+}
+
+// newEgressNetworkPolicy returns a network policy for the pods of the instance.
+// The CIDRs of a hostname are determined by lookup.
+// If it could not be determined, it returns an error,
+// or allows the port to any address if allowUnresolved is true.
+// DNS is allowed only to the cluster DNS.
+func newEgressNetworkPolicy(ctx context.Context, lookup lookupCIDRFunc, namespace, instanceID string, tunnels []tunnel.Tunnel, allowUnresolved bool) (*networkingv1.NetworkPolicy, error) {
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	dnsPort := intstr.FromInt(53)
+	rules := []networkingv1.NetworkPolicyEgressRule{
+		{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dnsPort},
+				{Protocol: &tcp, Port: &dnsPort},
+			},
+			To: []networkingv1.NetworkPolicyPeer{
+				{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabelKey: clusterDNSNamespace}},
+					PodSelector:       &metav1.LabelSelector{MatchLabels: clusterDNSPodLabels},
+				},
+			},
+		},
+	}
+	for _, t := range tunnels {
+		for _, e := range t.Upstreams() {
+			port := intstr.FromInt(e.Port)
+			rule := networkingv1.NetworkPolicyEgressRule{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+			}
+			var cidrs []string
+			if ip := net.ParseIP(e.Host); ip != nil {
+				cidrs = []string{hostCIDR(ip)}
+			} else {
+				resolved, err := lookup(ctx, e.Host)
+				if err != nil {
+					if !allowUnresolved {
+						return nil, fmt.Errorf("network policy: could not resolve %s: %w", e.Host, err)
+					}
+					klog.Infof("network policy allows port %d to any address: could not resolve %s: %s", e.Port, e.Host, err)
+				}
+				cidrs = resolved
+			}
+			for _, cidr := range cidrs {
+				rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{
+					IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+				})
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", appNameLabelValue, instanceID),
+			Namespace: namespace,
+			Labels: map[string]string{
+				appNameLabelKey: appNameLabelValue,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					instanceLabelKey: instanceID,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      rules,
+		},
+	}, nil
+}
+
+func hostCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
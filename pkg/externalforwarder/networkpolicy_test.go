@@ -0,0 +1,163 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewEgressNetworkPolicy(t *testing.T) {
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		if host == "db.staging" {
+			return []string{"10.0.0.0/24", "10.0.1.0/24"}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+	tunnels := []tunnel.Tunnel{
+		{RemoteHost: "db.staging", RemotePort: 5432},
+		{RemoteHost: "192.168.1.1", RemotePort: 443},
+		{RemoteHost: "unknown.internal", RemotePort: 22},
+	}
+	got, err := newEgressNetworkPolicy(context.TODO(), lookup, "default", "0123456789abcdef", tunnels, true)
+	if err != nil {
+		t.Fatalf("newEgressNetworkPolicy error: %s", err)
+	}
+
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	port := func(p int) *intstr.IntOrString {
+		v := intstr.FromInt(p)
+		return &v
+	}
+	ipBlock := func(cidr string) networkingv1.NetworkPolicyPeer {
+		return networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}
+	}
+	want := networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{
+			MatchLabels: map[string]string{instanceLabelKey: "0123456789abcdef"},
+		},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		Egress: []networkingv1.NetworkPolicyEgressRule{
+			{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: port(53)}, {Protocol: &tcp, Port: port(53)}},
+				To: []networkingv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"}},
+						PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+					},
+				},
+			},
+			{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: port(5432)}},
+				To:    []networkingv1.NetworkPolicyPeer{ipBlock("10.0.0.0/24"), ipBlock("10.0.1.0/24")},
+			},
+			{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: port(443)}},
+				To:    []networkingv1.NetworkPolicyPeer{ipBlock("192.168.1.1/32")},
+			},
+			{Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: port(22)}}},
+		},
+	}
+	if diff := cmp.Diff(want, got.Spec); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if got.Name != "kubectl-external-forward-0123456789abcdef" {
+		t.Errorf("name wants kubectl-external-forward-0123456789abcdef but got %s", got.Name)
+	}
+
+	t.Run("Unresolved", func(t *testing.T) {
+		_, err := newEgressNetworkPolicy(context.TODO(), lookup, "default", "0123456789abcdef", tunnels, false)
+		if err == nil || !strings.Contains(err.Error(), "unknown.internal") {
+			t.Errorf("error wants unknown.internal but got %v", err)
+		}
+	})
+}
+
+func TestClusterLookupCIDR(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "staging", Name: "db"}},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "staging", Name: "db"},
+			Subsets: []corev1.EndpointSubset{
+				{Addresses: []corev1.EndpointAddress{{IP: "10.1.0.1"}, {IP: "10.1.0.2"}}},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "staging", Name: "external"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "db.staging.svc.cluster.local"},
+		},
+	)
+	lookup := newClusterLookupCIDR(c, map[string][]string{"db.example.com": {"192.168.0.0/24"}})
+	for _, host := range []string{"db.staging.svc", "db.staging.svc.cluster.local", "external.staging.svc"} {
+		t.Run(host, func(t *testing.T) {
+			got, err := lookup(context.TODO(), host)
+			if err != nil {
+				t.Fatalf("lookup error: %s", err)
+			}
+			want := []string{"10.1.0.1/32", "10.1.0.2/32"}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	t.Run("EgressCIDRs", func(t *testing.T) {
+		got, err := lookup(context.TODO(), "db.example.com")
+		if err != nil {
+			t.Fatalf("lookup error: %s", err)
+		}
+		if diff := cmp.Diff([]string{"192.168.0.0/24"}, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("OutsideCluster", func(t *testing.T) {
+		// the local resolver should not be used
+		_, err := lookup(context.TODO(), "localhost")
+		if err == nil || !strings.Contains(err.Error(), "give the CIDRs explicitly") {
+			t.Errorf("error wants the CIDRs explicitly but got %v", err)
+		}
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := lookup(context.TODO(), "missing.staging.svc"); err == nil {
+			t.Errorf("lookup wants error but got nil")
+		}
+	})
+}
+
+func TestCreatePod_NetworkPolicy(t *testing.T) {
+	c := newFakeClientset(corev1.PodRunning)
+	g := &podGroup{
+		Namespace: "default",
+		Tunnels:   []tunnel.Tunnel{{RemoteHost: "192.168.1.1", RemotePort: 443, ContainerPort: 10000}},
+		clientset: c,
+	}
+	if err := createPod(context.TODO(), g, podOption{image: DefaultPodImage, networkPolicy: true}, nil); err != nil {
+		t.Fatalf("createPod error: %s", err)
+	}
+	var actions []string
+	for _, action := range c.Actions() {
+		switch action.GetResource().Resource {
+		case "pods", "networkpolicies":
+			actions = append(actions, action.GetVerb()+" "+action.GetResource().Resource)
+		}
+	}
+	// the pod should not run without the network policy
+	want := []string{"create networkpolicies", "create pods", "patch networkpolicies"}
+	if diff := cmp.Diff(want, actions); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	np, err := c.NetworkingV1().NetworkPolicies("default").Get(context.TODO(), "kubectl-external-forward-"+g.pod.Labels[instanceLabelKey], metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if len(np.OwnerReferences) != 1 || np.OwnerReferences[0].Name != g.pod.Name {
+		t.Errorf("owner wants the pod %s but got %+v", g.pod.Name, np.OwnerReferences)
+	}
+}
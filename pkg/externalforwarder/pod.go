@@ -5,10 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -16,28 +18,35 @@ import (
 	"k8s.io/klog/v2"
 )
 
-func newPod(o Option) (*corev1.Pod, error) {
+const (
+	appNameLabelKey   = "app.kubernetes.io/name"
+	appNameLabelValue = "kubectl-external-forward"
+)
+
+func newPod(tunnels []tunnel.Tunnel, image string, envoyOption envoy.Option) (*corev1.Pod, error) {
+	envoyConfig, err := envoy.NewConfig(tunnels, envoyOption)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate envoy config: %w", err)
+	}
+
 	pod := corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "kubectl-external-forward-",
+			Labels: map[string]string{
+				appNameLabelKey: appNameLabelValue,
+			},
 			Annotations: map[string]string{
 				// do not prevent scale-in of cluster autoscaler
 				"cluster-autoscaler.kubernetes.io/safe-to-evict": "true",
-				"sidecar.istio.io/inject": "false",
+				"sidecar.istio.io/inject":                        "false",
 			},
 		},
 		Spec: corev1.PodSpec{},
 	}
-
-	envoyConfig, err := envoy.NewConfig(o.Tunnels)
-	if err != nil {
-		return nil, fmt.Errorf("could not generate envoy config: %w", err)
-	}
-
 	pod.Spec.Containers = []corev1.Container{
 		{
 			Name:  "envoy",
-			Image: o.PodImage,
+			Image: image,
 			Args: []string{
 				"--config-yaml",
 				envoyConfig,
@@ -53,15 +62,21 @@ func newPod(o Option) (*corev1.Pod, error) {
 	return &pod, nil
 }
 
-func waitForPodRunning(ctx context.Context, c *kubernetes.Clientset, namespace, name string, timeout time.Duration) error {
+// waitForPodRunning waits until the pod is running, and returns the pod.
+func waitForPodRunning(ctx context.Context, c kubernetes.Interface, namespace, name string, timeout time.Duration) (*corev1.Pod, error) {
+	var running *corev1.Pod
 	checkIfRunning := func() error {
 		pod, err := c.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
 			return backoff.Permanent(err)
 		}
+		if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			return backoff.Permanent(fmt.Errorf("pod %s/%s has %s: %s", pod.Namespace, pod.Name, pod.Status.Phase, pod.Status.Message))
+		}
 		if pod.Status.Phase != corev1.PodRunning {
 			return fmt.Errorf("pod %s/%s is still %s", pod.Namespace, pod.Name, pod.Status.Phase)
 		}
+		running = pod
 		return nil
 	}
 	notify := func(err error, d time.Duration) {
@@ -72,12 +87,14 @@ func waitForPodRunning(ctx context.Context, c *kubernetes.Clientset, namespace,
 	b.MaxElapsedTime = timeout
 	b.MaxInterval = 3 * time.Second
 	if err := backoff.RetryNotify(checkIfRunning, backoff.WithContext(b, ctx), notify); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return running, nil
 }
 
-func tailPodLogs(ctx context.Context, c *kubernetes.Clientset, namespace, name, containerName string) error {
+// tailPodLogs follows the logs of the container.
+// It calls handleLine for each line.
+func tailPodLogs(ctx context.Context, c kubernetes.Interface, namespace, name, containerName string, handleLine func(line string)) error {
 	opts := corev1.PodLogOptions{
 		Follow:    true,
 		Container: containerName,
@@ -87,20 +104,20 @@ func tailPodLogs(ctx context.Context, c *kubernetes.Clientset, namespace, name,
 		return fmt.Errorf("could not get logs from pod: %w", err)
 	}
 	defer stream.Close()
+	r := bufio.NewReader(stream)
 	for {
-		r := bufio.NewReader(stream)
-		l, _, err := r.ReadLine()
+		l, err := r.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
 				return nil
 			}
 			return fmt.Errorf("read error: %w", err)
 		}
-		klog.Infof("%s/%s/%s: %s", namespace, name, containerName, l)
+		handleLine(strings.TrimRight(l, "\r\n"))
 	}
 }
 
-func deletePodWithRetry(ctx context.Context, c *kubernetes.Clientset, namespace, name string, timeout time.Duration) error {
+func deletePodWithRetry(ctx context.Context, c kubernetes.Interface, namespace, name string, timeout time.Duration) error {
 	attempt := func() error {
 		err := c.CoreV1().Pods(namespace).Delete(ctx, name, *metav1.NewDeleteOptions(0))
 		if err != nil {
@@ -0,0 +1,105 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/int128/kubectl-external-forward/pkg/policy"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const policyVersionAnnotationKey = "kubectl-external-forward/policy-version"
+
+// loadPolicy returns the policy in the ConfigMap of NAMESPACE/NAME.
+// It returns nil if ref is empty or the ConfigMap does not exist.
+// It returns an error if the ConfigMap is not readable, such as forbidden,
+// because the policy would be bypassed silently.
+func loadPolicy(ctx context.Context, c kubernetes.Interface, ref string) (*policy.Policy, error) {
+	if ref == "" {
+		return nil, nil
+	}
+	namespace, name, ok := splitRef(ref)
+	if !ok {
+		return nil, fmt.Errorf("invalid policy ConfigMap %s: must be NAMESPACE/NAME", ref)
+	}
+	cm, err := c.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.V(1).Infof("no policy ConfigMap %s", ref)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the policy ConfigMap %s: %w", ref, err)
+	}
+	data, ok := cm.Data[policy.DataKey]
+	if !ok {
+		return nil, fmt.Errorf("policy ConfigMap %s has no key %s", ref, policy.DataKey)
+	}
+	p, err := policy.Parse([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy ConfigMap %s: %w", ref, err)
+	}
+	if p.Version == "" {
+		p.Version = cm.ResourceVersion
+	}
+	klog.Infof("loaded the policy %s (version %s)", ref, p.Version)
+	return p, nil
+}
+
+// loadPolicies loads the policy of the cluster of each group.
+func loadPolicies(ctx context.Context, groups []*podGroup, ref string) error {
+	for _, g := range groups {
+		p, err := loadPolicy(ctx, g.clientset, ref)
+		if err != nil {
+			return err
+		}
+		g.policy = p
+	}
+	return nil
+}
+
+func splitRef(ref string) (string, string, bool) {
+	s := strings.SplitN(ref, "/", 2)
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", false
+	}
+	return s[0], s[1], true
+}
+
+// enforcePolicy returns an error if any destination is not allowed by the policy.
+// The original and resolved tunnels must be in the same order.
+//
+// A host is verified as specified, except a SRV record which is not a destination.
+// The resolved hosts are verified as well, because a SRV record or ExternalName service may point to any host.
+// The endpoints of a service in the cluster are allowed by the service.
+func enforcePolicy(p *policy.Policy, namespace string, original, resolved []tunnel.Tunnel) error {
+	if p == nil {
+		return nil
+	}
+	allow := func(t tunnel.Tunnel) error {
+		for _, e := range t.Upstreams() {
+			if err := p.Allow(namespace, e.Host, e.Port); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, t := range original {
+		if !t.IsSRV() {
+			if err := allow(t); err != nil {
+				return err
+			}
+		}
+		if _, _, ok := t.ServiceRef(); ok && resolved[i].DiscoveryType == "STATIC" {
+			continue
+		}
+		if err := allow(resolved[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
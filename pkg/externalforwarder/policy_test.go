@@ -0,0 +1,118 @@
+package externalforwarder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/int128/kubectl-external-forward/pkg/policy"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "external-forward-policy", ResourceVersion: "123"},
+			Data: map[string]string{
+				"policy.yaml": `rules: [{hosts: ["*.staging.internal"], ports: [5432]}]`,
+			},
+		},
+	)
+
+	t.Run("NotFound", func(t *testing.T) {
+		p, err := loadPolicy(ctx, c, "kube-system/missing")
+		if err != nil {
+			t.Fatalf("loadPolicy error: %s", err)
+		}
+		if p != nil {
+			t.Errorf("policy wants nil but got %+v", p)
+		}
+	})
+
+	t.Run("Enforce", func(t *testing.T) {
+		p, err := loadPolicy(ctx, c, "kube-system/external-forward-policy")
+		if err != nil {
+			t.Fatalf("loadPolicy error: %s", err)
+		}
+		if p.Version != "123" {
+			t.Errorf("Version wants 123 but got %s", p.Version)
+		}
+		allowed := []tunnel.Tunnel{{RemoteHost: "db.staging.internal", RemotePort: 5432}}
+		if err := enforcePolicy(p, "default", allowed, allowed); err != nil {
+			t.Errorf("enforcePolicy error: %s", err)
+		}
+		denied := []tunnel.Tunnel{
+			{RemoteHost: "db.staging.internal", RemotePort: 5432},
+			{RemoteHost: "db.production.internal", RemotePort: 5432},
+		}
+		if err := enforcePolicy(p, "default", denied, denied); err == nil {
+			t.Errorf("enforcePolicy wants error but got nil")
+		}
+		srv := []tunnel.Tunnel{{RemoteHost: "_postgres._tcp.staging.internal"}}
+		resolved := []tunnel.Tunnel{{
+			RemoteHost: "_postgres._tcp.staging.internal",
+			Endpoints:  []tunnel.Endpoint{{Host: "db-1.staging.internal", Port: 5432}},
+		}}
+		if err := enforcePolicy(p, "default", srv, resolved); err != nil {
+			t.Errorf("enforcePolicy error: %s", err)
+		}
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		c := fake.NewSimpleClientset()
+		c.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "external-forward-policy", errors.New("denied"))
+		})
+		if _, err := loadPolicy(ctx, c, "kube-system/external-forward-policy"); err == nil {
+			t.Errorf("loadPolicy wants error but got nil")
+		}
+	})
+}
+
+func TestEnforcePolicy_Service(t *testing.T) {
+	p, err := policy.Parse([]byte(`rules: [{hosts: ["svc/*", "*.staging.internal"]}]`))
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	svc := []tunnel.Tunnel{{RemoteHost: "svc/db", RemotePort: 5432}}
+
+	t.Run("Endpoints", func(t *testing.T) {
+		resolved := []tunnel.Tunnel{{
+			RemoteHost:    "svc/db",
+			RemotePort:    5432,
+			DiscoveryType: "STATIC",
+			Endpoints:     []tunnel.Endpoint{{Host: "10.1.2.3", Port: 5432}},
+		}}
+		if err := enforcePolicy(p, "default", svc, resolved); err != nil {
+			t.Errorf("enforcePolicy error: %s", err)
+		}
+	})
+
+	t.Run("ExternalName", func(t *testing.T) {
+		allowed := []tunnel.Tunnel{{
+			RemoteHost:    "svc/db",
+			RemotePort:    5432,
+			DiscoveryType: "STRICT_DNS",
+			Endpoints:     []tunnel.Endpoint{{Host: "db.staging.internal", Port: 5432}},
+		}}
+		if err := enforcePolicy(p, "default", svc, allowed); err != nil {
+			t.Errorf("enforcePolicy error: %s", err)
+		}
+		denied := []tunnel.Tunnel{{
+			RemoteHost:    "svc/db",
+			RemotePort:    5432,
+			DiscoveryType: "STRICT_DNS",
+			Endpoints:     []tunnel.Endpoint{{Host: "db.production.internal", Port: 5432}},
+		}}
+		if err := enforcePolicy(p, "default", svc, denied); err == nil {
+			t.Errorf("enforcePolicy wants error but got nil")
+		}
+	})
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package externalforwarder
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// processExists returns true if the process exists.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// the process may be owned by another user
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,13 @@
+package externalforwarder
+
+import "os"
+
+// processExists returns true if the process exists.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
@@ -0,0 +1,171 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// permission represents an access to a resource.
+// Group is empty for the core group.
+type permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+}
+
+func (p permission) resourceName() string {
+	if p.Subresource != "" {
+		return p.Resource + "/" + p.Subresource
+	}
+	return p.Resource
+}
+
+// requiredPermissions returns the permissions to run the tunnels in a namespace.
+func requiredPermissions(o Option, tunnels []tunnel.Tunnel) []permission {
+	perms := []permission{
+		{Verb: "create", Resource: "pods"},
+		{Verb: "get", Resource: "pods"},
+		{Verb: "watch", Resource: "pods"},
+		{Verb: "delete", Resource: "pods"},
+		{Verb: "get", Resource: "pods", Subresource: "log"},
+		{Verb: "create", Resource: "pods", Subresource: "portforward"},
+		// events of the tunnels are recorded on the pod
+		{Verb: "create", Resource: "events"},
+	}
+	for _, t := range tunnels {
+		_, _, isService := t.ServiceRef()
+		// a service name is resolved via the API to create a network policy
+		_, _, isClusterServiceName := clusterServiceRef(t.RemoteHost)
+		if isService || (o.NetworkPolicy && isClusterServiceName) {
+			perms = append(perms,
+				permission{Verb: "get", Resource: "services"},
+				permission{Verb: "get", Resource: "endpoints"},
+			)
+			break
+		}
+	}
+	if o.NetworkPolicy {
+		perms = append(perms,
+			permission{Verb: "create", Group: "networking.k8s.io", Resource: "networkpolicies"},
+			permission{Verb: "patch", Group: "networking.k8s.io", Resource: "networkpolicies"},
+			permission{Verb: "delete", Group: "networking.k8s.io", Resource: "networkpolicies"},
+		)
+	}
+	return perms
+}
+
+// missingPermissions returns the permissions which are not allowed in the namespace.
+func missingPermissions(ctx context.Context, c kubernetes.Interface, namespace string, perms []permission) ([]permission, error) {
+	var missing []permission
+	for _, p := range perms {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+				},
+			},
+		}
+		result, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not review access to %s %s: %w", p.Verb, p.resourceName(), err)
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// preflightPermissions verifies the permissions of each group before creating any pod.
+// If any permission is missing, it writes a report to w and returns an error.
+// If the access review is not available, it skips the check.
+func preflightPermissions(ctx context.Context, o Option, groups []*podGroup, w io.Writer) error {
+	var report []groupPermissions
+	for _, g := range groups {
+		perms := requiredPermissions(o, g.Tunnels)
+		missing, err := missingPermissions(ctx, g.clientset, g.Namespace, perms)
+		if err != nil {
+			klog.Infof("skipped the permission check of %s: %s", g, err)
+			continue
+		}
+		if len(missing) > 0 {
+			report = append(report, groupPermissions{group: g, required: perms, missing: missing})
+		}
+	}
+	if len(report) == 0 {
+		return nil
+	}
+	if err := writePermissionReport(w, report); err != nil {
+		return err
+	}
+	return fmt.Errorf("missing permissions in %d namespace(s)", len(report))
+}
+
+type groupPermissions struct {
+	group    *podGroup
+	required []permission
+	missing  []permission
+}
+
+func writePermissionReport(w io.Writer, report []groupPermissions) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "You do not have the following permissions:")
+	_, _ = fmt.Fprintln(tw, "NAMESPACE\tVERB\tRESOURCE")
+	for _, r := range report {
+		for _, p := range r.missing {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", r.group, p.Verb, p.resourceName())
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(w, "\nAsk your cluster administrator to bind the following role:")
+	for _, r := range report {
+		_, _ = fmt.Fprintf(w, "---\n%s", roleManifest(r.group.Namespace, r.required))
+	}
+	return nil
+}
+
+// roleManifest returns a manifest of Role which allows the permissions.
+func roleManifest(namespace string, perms []permission) string {
+	type groupResource struct{ group, name string }
+	var resources []groupResource
+	verbs := make(map[groupResource][]string)
+	for _, p := range perms {
+		r := groupResource{p.Group, p.resourceName()}
+		if verbs[r] == nil {
+			resources = append(resources, r)
+		}
+		verbs[r] = append(verbs[r], p.Verb)
+	}
+	var b strings.Builder
+	b.WriteString("apiVersion: rbac.authorization.k8s.io/v1\n")
+	b.WriteString("kind: Role\n")
+	b.WriteString("metadata:\n")
+	b.WriteString("  name: kubectl-external-forward\n")
+	fmt.Fprintf(&b, "  namespace: %s\n", namespace)
+	b.WriteString("rules:\n")
+	for _, r := range resources {
+		v := verbs[r]
+		sort.Strings(v)
+		fmt.Fprintf(&b, "  - apiGroups: [\"%s\"]\n", r.group)
+		fmt.Fprintf(&b, "    resources: [\"%s\"]\n", r.name)
+		fmt.Fprintf(&b, "    verbs: [\"%s\"]\n", strings.Join(v, "\", \""))
+	}
+	return b.String()
+}
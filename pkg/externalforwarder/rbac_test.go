@@ -0,0 +1,74 @@
+package externalforwarder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewSimpleClientset()
+	c.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		// deny port-forwarding in the staging namespace
+		review.Status.Allowed = !(attrs.Namespace == "staging" && attrs.Subresource == "portforward")
+		return true, review, nil
+	})
+	perms := requiredPermissions(Option{}, nil)
+
+	t.Run("Allowed", func(t *testing.T) {
+		missing, err := missingPermissions(ctx, c, "default", perms)
+		if err != nil {
+			t.Fatalf("missingPermissions error: %s", err)
+		}
+		if len(missing) != 0 {
+			t.Errorf("missing wants empty but got %v", missing)
+		}
+	})
+	t.Run("Denied", func(t *testing.T) {
+		missing, err := missingPermissions(ctx, c, "staging", perms)
+		if err != nil {
+			t.Fatalf("missingPermissions error: %s", err)
+		}
+		want := []permission{{Verb: "create", Resource: "pods", Subresource: "portforward"}}
+		if diff := cmp.Diff(want, missing); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestRoleManifest(t *testing.T) {
+	got := roleManifest("staging", requiredPermissions(Option{NetworkPolicy: true}, nil))
+	want := `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: kubectl-external-forward
+  namespace: staging
+rules:
+  - apiGroups: [""]
+    resources: ["pods"]
+    verbs: ["create", "delete", "get", "watch"]
+  - apiGroups: [""]
+    resources: ["pods/log"]
+    verbs: ["get"]
+  - apiGroups: [""]
+    resources: ["pods/portforward"]
+    verbs: ["create"]
+  - apiGroups: [""]
+    resources: ["events"]
+    verbs: ["create"]
+  - apiGroups: ["networking.k8s.io"]
+    resources: ["networkpolicies"]
+    verbs: ["create", "delete", "patch"]
+`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,120 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// resolveTunnels resolves the destination of each tunnel.
+// A SRV record is resolved by the local resolver.
+// A Kubernetes Service is resolved by the API in the namespace of the pod if omitted.
+// Any other hosts are resolved by Envoy in the pod.
+func resolveTunnels(ctx context.Context, c kubernetes.Interface, namespace string, tunnels []tunnel.Tunnel) ([]tunnel.Tunnel, error) {
+	var resolved []tunnel.Tunnel
+	for _, t := range tunnels {
+		if t.IsSRV() {
+			r, err := resolveSRV(ctx, net.DefaultResolver, t)
+			if err != nil {
+				return nil, fmt.Errorf("could not resolve SRV record %s: %w", t.RemoteHost, err)
+			}
+			t = r
+		}
+		if name, ns, ok := t.ServiceRef(); ok {
+			if ns == "" {
+				ns = namespace
+			}
+			r, err := resolveService(ctx, c, ns, name, t)
+			if err != nil {
+				return nil, fmt.Errorf("could not resolve service %s/%s: %w", ns, name, err)
+			}
+			t = r
+		}
+		resolved = append(resolved, t)
+	}
+	return resolved, nil
+}
+
+// resolveSRV sets the targets of the SRV record to the endpoints.
+// It uses only the records of the highest priority.
+func resolveSRV(ctx context.Context, r *net.Resolver, t tunnel.Tunnel) (tunnel.Tunnel, error) {
+	_, addrs, err := r.LookupSRV(ctx, "", "", t.RemoteHost)
+	if err != nil {
+		return t, err
+	}
+	if len(addrs) == 0 {
+		return t, fmt.Errorf("no record found")
+	}
+	// addrs are sorted by priority
+	priority := addrs[0].Priority
+	var endpoints []tunnel.Endpoint
+	for _, addr := range addrs {
+		if addr.Priority != priority {
+			break
+		}
+		endpoints = append(endpoints, tunnel.Endpoint{
+			Host: strings.TrimSuffix(addr.Target, "."),
+			Port: int(addr.Port),
+		})
+	}
+	klog.Infof("resolved SRV record %s to %v", t.RemoteHost, endpoints)
+	t.DiscoveryType = "STRICT_DNS"
+	t.Endpoints = endpoints
+	t.RemotePort = endpoints[0].Port
+	return t, nil
+}
+
+// resolveService sets the endpoints of the service.
+// If the service is ExternalName, Envoy resolves the external name.
+// Otherwise, Envoy connects to the ready addresses of the endpoints.
+func resolveService(ctx context.Context, c kubernetes.Interface, namespace, name string, t tunnel.Tunnel) (tunnel.Tunnel, error) {
+	svc, err := c.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return t, fmt.Errorf("could not get the service: %w", err)
+	}
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		t.DiscoveryType = "STRICT_DNS"
+		t.Endpoints = []tunnel.Endpoint{{Host: svc.Spec.ExternalName, Port: t.RemotePort}}
+		klog.Infof("resolved service %s/%s to %v", namespace, name, t.Endpoints)
+		return t, nil
+	}
+
+	var servicePort *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if int(svc.Spec.Ports[i].Port) == t.RemotePort {
+			servicePort = &svc.Spec.Ports[i]
+		}
+	}
+	if servicePort == nil {
+		return t, fmt.Errorf("service has no port %d", t.RemotePort)
+	}
+	ep, err := c.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return t, fmt.Errorf("could not get the endpoints: %w", err)
+	}
+	var endpoints []tunnel.Endpoint
+	for _, subset := range ep.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != servicePort.Name {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				endpoints = append(endpoints, tunnel.Endpoint{Host: addr.IP, Port: int(port.Port)})
+			}
+		}
+	}
+	if len(endpoints) == 0 {
+		return t, fmt.Errorf("no ready endpoint for port %d", t.RemotePort)
+	}
+	klog.Infof("resolved service %s/%s to %v", namespace, name, endpoints)
+	t.DiscoveryType = "STATIC"
+	t.Endpoints = endpoints
+	return t, nil
+}
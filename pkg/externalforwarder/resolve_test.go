@@ -0,0 +1,91 @@
+package externalforwarder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveTunnels(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "staging", Name: "db"},
+			Spec: corev1.ServiceSpec{
+				Type:  corev1.ServiceTypeClusterIP,
+				Ports: []corev1.ServicePort{{Name: "postgres", Port: 5432}},
+			},
+		},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "staging", Name: "db"},
+			Subsets: []corev1.EndpointSubset{
+				{
+					Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+					Ports:     []corev1.EndpointPort{{Name: "postgres", Port: 15432}},
+				},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"},
+			Spec: corev1.ServiceSpec{
+				Type:         corev1.ServiceTypeExternalName,
+				ExternalName: "api.example.com",
+			},
+		},
+	)
+
+	t.Run("Service", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{{LocalPort: 15432, RemoteHost: "svc/db.staging", RemotePort: 5432}}
+		got, err := resolveTunnels(ctx, c, "default", tunnels)
+		if err != nil {
+			t.Fatalf("resolveTunnels error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{
+				LocalPort:     15432,
+				RemoteHost:    "svc/db.staging",
+				RemotePort:    5432,
+				DiscoveryType: "STATIC",
+				Endpoints: []tunnel.Endpoint{
+					{Host: "10.0.0.1", Port: 15432},
+					{Host: "10.0.0.2", Port: 15432},
+				},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ExternalName", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{{LocalPort: 10443, RemoteHost: "svc/api", RemotePort: 443}}
+		got, err := resolveTunnels(ctx, c, "default", tunnels)
+		if err != nil {
+			t.Fatalf("resolveTunnels error: %s", err)
+		}
+		want := []tunnel.Tunnel{
+			{
+				LocalPort:     10443,
+				RemoteHost:    "svc/api",
+				RemotePort:    443,
+				DiscoveryType: "STRICT_DNS",
+				Endpoints:     []tunnel.Endpoint{{Host: "api.example.com", Port: 443}},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("NoSuchPort", func(t *testing.T) {
+		tunnels := []tunnel.Tunnel{{LocalPort: 13306, RemoteHost: "svc/db.staging", RemotePort: 3306}}
+		if _, err := resolveTunnels(ctx, c, "default", tunnels); err == nil {
+			t.Errorf("resolveTunnels wants error but was nil")
+		}
+	})
+}
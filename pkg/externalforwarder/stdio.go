@@ -0,0 +1,125 @@
+package externalforwarder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/signal"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// stdioContainerPort is the port which Envoy listens on in stdio mode.
+const stdioContainerPort = 10000
+
+// StdioOption represents an option of ExternalForwarder.Stdio.
+type StdioOption struct {
+	Config     *rest.Config
+	Namespace  string
+	PodImage   string
+	RemoteHost string
+	RemotePort int
+	Stdin      io.Reader
+	Stdout     io.Writer
+	// If set, refuse the remote host which is not allowed by the policy in the ConfigMap of NAMESPACE/NAME
+	PolicyConfigMap string
+	// Version and KubeconfigUser are recorded in the pod
+	Version        string
+	KubeconfigUser string
+}
+
+// Stdio forwards a single connection between stdin/stdout and the remote host.
+// It is intended to be used as ProxyCommand of SSH.
+//
+// It creates a pod for each connection and deletes it when the connection is closed,
+// because another connection may be still using a pod.
+func (f ExternalForwarder) Stdio(ctx context.Context, o StdioOption) error {
+	clientset, err := f.newClientset(o.Config)
+	if err != nil {
+		return fmt.Errorf("could not create a client set: %w", err)
+	}
+	tunnels := []tunnel.Tunnel{
+		{
+			ContainerPort: stdioContainerPort,
+			RemoteHost:    o.RemoteHost,
+			RemotePort:    o.RemotePort,
+		},
+	}
+	p, err := loadPolicy(ctx, clientset, o.PolicyConfigMap)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveTunnels(ctx, clientset, o.Namespace, tunnels)
+	if err != nil {
+		return err
+	}
+	if err := enforcePolicy(p, o.Namespace, tunnels, resolved); err != nil {
+		return err
+	}
+	pod, err := newPod(resolved, o.PodImage, envoy.Option{})
+	if err != nil {
+		return fmt.Errorf("could not generate pod spec: %w", err)
+	}
+	if p != nil {
+		pod.Annotations[policyVersionAnnotationKey] = p.Version
+	}
+	newAuditInfo(ctx, clientset, f.whoAmI(), o.Version, o.KubeconfigUser).apply(pod, resolved)
+
+	// a pod is cleaned up on interrupt
+	ctx, stop := signal.NotifyContext(ctx, interruptSignals...)
+	defer stop()
+	klog.Infof("creating a pod")
+	pod, err = clientset.CoreV1().Pods(o.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("could not create pod: %w", err)
+	}
+	klog.Infof("created pod %s/%s", pod.Namespace, pod.Name)
+	defer func() {
+		if err := cleanupPod(clientset, pod, "", nil); err != nil {
+			klog.Info(err)
+		}
+	}()
+
+	if _, err := waitForPodRunning(ctx, clientset, pod.Namespace, pod.Name, 60*time.Second); err != nil {
+		return fmt.Errorf("pod is not running: %w", err)
+	}
+	klog.V(1).Infof("connecting to %s/%s:%d", pod.Namespace, pod.Name, stdioContainerPort)
+	so := portforwarder.StreamOption{
+		Config:              o.Config,
+		TargetNamespace:     pod.Namespace,
+		TargetPodName:       pod.Name,
+		TargetContainerPort: stdioContainerPort,
+	}
+	if err := f.PortForwarder.Stream(ctx, so, o.Stdin, o.Stdout); err != nil {
+		return fmt.Errorf("could not forward the connection: %w", err)
+	}
+	klog.V(1).Infof("connection closed")
+	return nil
+}
+
+// podKey returns the namespace and name of the pod.
+func podKey(pod *corev1.Pod) string {
+	return fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+}
+
+func cleanupPod(clientset kubernetes.Interface, pod *corev1.Pod, contextName string, events event.Emitter) error {
+	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(ctx, interruptSignals...)
+	defer stop()
+	klog.Infof("deleting pod %s/%s...", pod.Namespace, pod.Name)
+	if err := deletePodWithRetry(ctx, clientset, pod.Namespace, pod.Name, 60*time.Second); err != nil {
+		return fmt.Errorf("you need to delete pod %s/%s manually: %w", pod.Namespace, pod.Name, err)
+	}
+	klog.Infof("deleted pod %s/%s", pod.Namespace, pod.Name)
+	event.Emit(events, event.Event{Type: event.PodDeleted, Pod: podKey(pod), Context: contextName})
+	return nil
+}
@@ -0,0 +1,125 @@
+package externalforwarder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/fakeapiserver"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestExternalForwarder_Stdio(t *testing.T) {
+	upstream := fakeapiserver.StartEchoServer(t)
+	f := ExternalForwarder{PortForwarder: &portforwarder.PortForwarder{}}
+	newOption := func(s *fakeapiserver.Server, stdin io.Reader, stdout io.Writer) StdioOption {
+		return StdioOption{
+			Config:     s.Config(),
+			Namespace:  "default",
+			PodImage:   DefaultPodImage,
+			RemoteHost: upstream.IP.String(),
+			RemotePort: upstream.Port,
+			Stdin:      stdin,
+			Stdout:     stdout,
+		}
+	}
+
+	t.Run("Echo", func(t *testing.T) {
+		s := fakeapiserver.New()
+		defer s.Close()
+		var stdout bytes.Buffer
+		if err := f.Stdio(context.TODO(), newOption(s, strings.NewReader("hello"), &stdout)); err != nil {
+			t.Fatalf("Stdio error: %s", err)
+		}
+		if stdout.String() != "hello" {
+			t.Errorf("stdout wants hello but got %s", stdout.String())
+		}
+		if pods := s.Pods(); len(pods) != 0 {
+			t.Errorf("pods want none but got %d", len(pods))
+		}
+	})
+
+	t.Run("ConcurrentSessions", func(t *testing.T) {
+		s := fakeapiserver.New()
+		defer s.Close()
+		first := startStdioSession(t, f, newOption(s, nil, nil))
+		second := startStdioSession(t, f, newOption(s, nil, nil))
+		if pods := s.Pods(); len(pods) != 2 {
+			t.Errorf("pods want 2 but got %d", len(pods))
+		}
+		// the first session should not break the second session
+		first.close(t)
+		second.echo(t, "again")
+		second.close(t)
+		if pods := s.Pods(); len(pods) != 0 {
+			t.Errorf("pods want none but got %d", len(pods))
+		}
+	})
+
+	t.Run("PodFailed", func(t *testing.T) {
+		s := fakeapiserver.New()
+		defer s.Close()
+		s.PodPhase = corev1.PodFailed
+		err := f.Stdio(context.TODO(), newOption(s, strings.NewReader("hello"), io.Discard))
+		if err == nil || !strings.Contains(err.Error(), "has Failed") {
+			t.Errorf("error wants pod failure but got %v", err)
+		}
+		if pods := s.Pods(); len(pods) != 0 {
+			t.Errorf("pods want none but got %d", len(pods))
+		}
+	})
+}
+
+// stdioSession is a running session of Stdio.
+type stdioSession struct {
+	stdin  *io.PipeWriter
+	stdout *io.PipeReader
+	done   chan error
+}
+
+// startStdioSession starts a session with pipes of stdin and stdout.
+// The session is ready when it returns.
+func startStdioSession(t *testing.T, f ExternalForwarder, o StdioOption) *stdioSession {
+	t.Helper()
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	o.Stdin, o.Stdout = stdinReader, stdoutWriter
+	session := &stdioSession{stdin: stdinWriter, stdout: stdoutReader, done: make(chan error, 1)}
+	go func() {
+		session.done <- f.Stdio(context.TODO(), o)
+		_ = stdoutWriter.Close()
+	}()
+	session.echo(t, "hello")
+	return session
+}
+
+func (session *stdioSession) echo(t *testing.T, message string) {
+	t.Helper()
+	if _, err := session.stdin.Write([]byte(message)); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+	b := make([]byte, len(message))
+	if _, err := io.ReadFull(session.stdout, b); err != nil {
+		t.Fatalf("ReadFull error: %s", err)
+	}
+	if string(b) != message {
+		t.Errorf("stdout wants %s but got %s", message, b)
+	}
+}
+
+func (session *stdioSession) close(t *testing.T) {
+	t.Helper()
+	_ = session.stdin.Close()
+	select {
+	case err := <-session.done:
+		if err != nil {
+			t.Errorf("Stdio error: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the session did not finish")
+	}
+}
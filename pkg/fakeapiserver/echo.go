@@ -0,0 +1,57 @@
+package fakeapiserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// StartEchoServer starts a TCP server which writes back the received data.
+// It is intended to be an upstream of the tunnels in tests.
+// The server is closed when the test is finished.
+func StartEchoServer(t testing.TB) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// Echo sends the message to the address and returns the response.
+// It closes the write side after sending, and reads until the server closes the connection.
+func Echo(addr, message string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(message)); err != nil {
+		return "", err
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
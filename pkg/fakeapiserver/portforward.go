@@ -0,0 +1,228 @@
+package fakeapiserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// webSocketProtocols are the supported subprotocols of WebSocket.
+// An empty protocol is treated as the binary channel protocol.
+var webSocketProtocols = []string{"v4.channel.k8s.io", "channel.k8s.io"}
+
+// portForward upgrades the connection to SPDY or WebSocket.
+func (s *Server) portForward(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	p := s.getPod(namespace, name)
+	if p == nil {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name))
+		return
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		p.portForwardWebSocket(w, r)
+		return
+	}
+	p.portForwardSPDY(w, r)
+}
+
+// streamPair is a pair of the data and error streams of a request.
+type streamPair struct {
+	data, error httpstream.Stream
+}
+
+// portForwardSPDY serves the streams in the same way as kubelet.
+// A client creates a pair of the error and data streams for each connection.
+func (p *pod) portForwardSPDY(w http.ResponseWriter, r *http.Request) {
+	if _, err := httpstream.Handshake(r, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+		// Handshake has written the response
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	streams := make(chan httpstream.Stream)
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		select {
+		case streams <- stream:
+		case <-done:
+		}
+		return nil
+	})
+	if conn == nil {
+		// UpgradeResponse has written the response
+		return
+	}
+	defer conn.Close()
+
+	pairs := make(map[string]*streamPair)
+	for {
+		select {
+		case <-conn.CloseChan():
+			return
+		case <-p.deleted:
+			return
+		case stream := <-streams:
+			requestID := stream.Headers().Get(corev1.PortForwardRequestIDHeader)
+			pair := pairs[requestID]
+			if pair == nil {
+				pair = &streamPair{}
+				pairs[requestID] = pair
+			}
+			switch stream.Headers().Get(corev1.StreamType) {
+			case corev1.StreamTypeData:
+				pair.data = stream
+			case corev1.StreamTypeError:
+				pair.error = stream
+			default:
+				_ = stream.Reset()
+				continue
+			}
+			if pair.data != nil && pair.error != nil {
+				delete(pairs, requestID)
+				go p.forwardStreamPair(pair)
+			}
+		}
+	}
+}
+
+func (p *pod) forwardStreamPair(pair *streamPair) {
+	defer pair.error.Close()
+	defer pair.data.Close()
+	port, err := strconv.Atoi(pair.data.Headers().Get(corev1.PortHeader))
+	if err != nil {
+		_, _ = fmt.Fprintf(pair.error, "invalid port: %s", err)
+		return
+	}
+	if err := p.proxy.serve(port, pair.data, pair.data); err != nil {
+		_, _ = fmt.Fprintf(pair.error, "an error occurred forwarding %d -> %d: %s", port, port, err)
+	}
+}
+
+// portForwardWebSocket serves the channel protocol in the same way as kubelet.
+// The ports are given by the query parameter, and each port has a data and error channel.
+func (p *pod) portForwardWebSocket(w http.ResponseWriter, r *http.Request) {
+	var ports []int
+	for _, value := range r.URL.Query()["ports"] {
+		for _, s := range strings.Split(value, ",") {
+			port, err := strconv.Atoi(s)
+			if err != nil || port < 1 || port > 65535 {
+				writeError(w, apierrors.NewBadRequest(fmt.Sprintf("invalid port %q", s)))
+				return
+			}
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		writeError(w, apierrors.NewBadRequest("query parameter ports is required"))
+		return
+	}
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if len(config.Protocol) == 0 {
+				return nil
+			}
+			for _, protocol := range config.Protocol {
+				for _, supported := range webSocketProtocols {
+					if protocol == supported {
+						config.Protocol = []string{protocol}
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("unsupported protocols %v", config.Protocol)
+		},
+		Handler: func(ws *websocket.Conn) {
+			p.serveWebSocket(ws, ports)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (p *pod) serveWebSocket(ws *websocket.Conn, ports []int) {
+	defer ws.Close()
+	ws.PayloadType = websocket.BinaryFrame
+	var mu sync.Mutex
+	send := func(channel int, b []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return websocket.Message.Send(ws, append([]byte{byte(channel)}, b...))
+	}
+
+	var wg sync.WaitGroup
+	writers := make([]*io.PipeWriter, len(ports))
+	for i, port := range ports {
+		dataChannel, errorChannel := i*2, i*2+1
+		// the first message of each channel is the port in little endian
+		portBytes := []byte{byte(port), byte(port >> 8)}
+		if err := send(dataChannel, portBytes); err != nil {
+			return
+		}
+		if err := send(errorChannel, portBytes); err != nil {
+			return
+		}
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			err := p.proxy.serve(port, pr, channelWriter{send: send, channel: dataChannel})
+			_ = pr.Close()
+			if err != nil {
+				_ = send(errorChannel, []byte(fmt.Sprintf("an error occurred forwarding %d -> %d: %s", port, port, err)))
+			}
+		}(port)
+	}
+	// close the connection when all ports are finished
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	go func() {
+		select {
+		case <-finished:
+		case <-p.deleted:
+		}
+		_ = ws.Close()
+	}()
+
+	for {
+		var message []byte
+		if err := websocket.Message.Receive(ws, &message); err != nil {
+			break
+		}
+		if len(message) == 0 {
+			continue
+		}
+		channel := int(message[0])
+		if channel%2 != 0 || channel/2 >= len(writers) {
+			continue
+		}
+		_, _ = writers[channel/2].Write(message[1:])
+	}
+	for _, pw := range writers {
+		_ = pw.Close()
+	}
+}
+
+// channelWriter writes to a channel of WebSocket.
+type channelWriter struct {
+	send    func(channel int, b []byte) error
+	channel int
+}
+
+func (w channelWriter) Write(b []byte) (int, error) {
+	if err := w.send(w.channel, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,182 @@
+package fakeapiserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// proxy forwards a connection to the upstream of the port in place of Envoy.
+// It writes an access log for each connection.
+type proxy struct {
+	listeners map[int]proxyListener
+	logs      *logBuffer
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// proxyListener represents a listener of Envoy, which is bound to a cluster.
+type proxyListener struct {
+	index     int
+	upstreams []string
+}
+
+// envoyConfig represents the part of the Envoy config used by the proxy.
+type envoyConfig struct {
+	StaticResources struct {
+		Listeners []struct {
+			Address      envoyAddress `json:"address"`
+			FilterChains []struct {
+				Filters []struct {
+					TypedConfig struct {
+						Cluster string `json:"cluster"`
+					} `json:"typed_config"`
+				} `json:"filters"`
+			} `json:"filter_chains"`
+		} `json:"listeners"`
+		Clusters []struct {
+			Name           string `json:"name"`
+			LoadAssignment struct {
+				Endpoints []struct {
+					LBEndpoints []struct {
+						Endpoint struct {
+							Address envoyAddress `json:"address"`
+						} `json:"endpoint"`
+					} `json:"lb_endpoints"`
+				} `json:"endpoints"`
+			} `json:"load_assignment"`
+		} `json:"clusters"`
+	} `json:"static_resources"`
+}
+
+type envoyAddress struct {
+	SocketAddress struct {
+		Address   string `json:"address"`
+		PortValue int    `json:"port_value"`
+	} `json:"socket_address"`
+}
+
+// newProxy returns a proxy from the Envoy config in the arguments of the container.
+func newProxy(pod *corev1.Pod, logs *logBuffer) (*proxy, error) {
+	var configYAML string
+	for _, container := range pod.Spec.Containers {
+		for i, arg := range container.Args {
+			if arg == "--config-yaml" && i+1 < len(container.Args) {
+				configYAML = container.Args[i+1]
+			}
+		}
+	}
+	if configYAML == "" {
+		return nil, fmt.Errorf("no --config-yaml in the containers")
+	}
+	var config envoyConfig
+	if err := yaml.Unmarshal([]byte(configYAML), &config); err != nil {
+		return nil, fmt.Errorf("invalid envoy config: %w", err)
+	}
+	upstreams := make(map[string][]string)
+	for _, cluster := range config.StaticResources.Clusters {
+		for _, endpoints := range cluster.LoadAssignment.Endpoints {
+			for _, lb := range endpoints.LBEndpoints {
+				a := lb.Endpoint.Address.SocketAddress
+				upstreams[cluster.Name] = append(upstreams[cluster.Name], net.JoinHostPort(a.Address, strconv.Itoa(a.PortValue)))
+			}
+		}
+	}
+	p := &proxy{listeners: make(map[int]proxyListener), logs: logs, conns: make(map[net.Conn]bool)}
+	for i, listener := range config.StaticResources.Listeners {
+		var cluster string
+		for _, chain := range listener.FilterChains {
+			for _, filter := range chain.Filters {
+				cluster = filter.TypedConfig.Cluster
+			}
+		}
+		p.listeners[listener.Address.SocketAddress.PortValue] = proxyListener{index: i, upstreams: upstreams[cluster]}
+	}
+	return p, nil
+}
+
+// serve forwards the stream to the upstream of the port.
+// It returns an error if no listener is bound to the port.
+// If the upstream is not available, it closes the stream as Envoy does.
+func (p *proxy) serve(port int, r io.Reader, w io.Writer) error {
+	if p == nil {
+		return fmt.Errorf("envoy is not running")
+	}
+	listener, ok := p.listeners[port]
+	if !ok {
+		return fmt.Errorf("failed to connect to localhost:%d: connection refused", port)
+	}
+	start := time.Now()
+	var upstream net.Conn
+	var upstreamAddr string
+	for _, addr := range listener.upstreams {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			upstream, upstreamAddr = conn, addr
+			break
+		}
+	}
+	if upstream == nil {
+		// upstream connection failure
+		p.logs.printf("%s", accessLog(listener.index, start, 0, 0, "-", "UF"))
+		return nil
+	}
+	if !p.track(upstream) {
+		_ = upstream.Close()
+		return fmt.Errorf("envoy is not running")
+	}
+	defer p.untrack(upstream)
+
+	var received int64
+	go func() {
+		n, _ := io.Copy(upstream, r)
+		atomic.AddInt64(&received, n)
+		if c, ok := upstream.(*net.TCPConn); ok {
+			_ = c.CloseWrite()
+		}
+	}()
+	sent, _ := io.Copy(w, upstream)
+	p.logs.printf("%s", accessLog(listener.index, start, atomic.LoadInt64(&received), sent, upstreamAddr, "-"))
+	return nil
+}
+
+// track adds the connection to be closed on stop.
+// It returns false if the proxy has been stopped.
+func (p *proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = true
+	return true
+}
+
+func (p *proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+	_ = conn.Close()
+}
+
+// stop closes all connections as the container is terminated.
+func (p *proxy) stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+}
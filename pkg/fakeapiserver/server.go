@@ -0,0 +1,387 @@
+// Package fakeapiserver provides an in-process Kubernetes API server for tests.
+// It implements the subset of the API used by this plugin, that is,
+// pods, logs, portforward, events and SelfSubjectAccessReview.
+// A pod runs a TCP proxy in place of Envoy, which forwards to the upstreams in the config of Envoy.
+package fakeapiserver
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// NodeName is the node name of the pods.
+const NodeName = "fake-node"
+
+// Server is a fake API server on HTTPS.
+// It is safe for concurrent use.
+type Server struct {
+	// PodPhase is the phase of a created pod. Default to Running.
+	// It must be set before any request.
+	PodPhase corev1.PodPhase
+
+	ts     *httptest.Server
+	mu     sync.Mutex
+	pods   map[string]*pod
+	events []corev1.Event
+}
+
+// pod represents a running pod.
+type pod struct {
+	obj     *corev1.Pod
+	proxy   *proxy
+	logs    *logBuffer
+	deleted chan struct{}
+}
+
+// New starts a server.
+// Caller must close the server.
+func New() *Server {
+	s := &Server{pods: make(map[string]*pod)}
+	s.ts = httptest.NewTLSServer(s)
+	return s
+}
+
+// Close stops the server and the pods.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for key, p := range s.pods {
+		p.stop()
+		delete(s.pods, key)
+	}
+	s.mu.Unlock()
+	s.ts.Close()
+}
+
+// URL returns the base URL of the server.
+func (s *Server) URL() string {
+	return s.ts.URL
+}
+
+func (s *Server) caData() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ts.Certificate().Raw})
+}
+
+// Config returns a client config of the server.
+func (s *Server) Config() *rest.Config {
+	return &rest.Config{
+		Host:            s.ts.URL,
+		BearerToken:     "fake",
+		TLSClientConfig: rest.TLSClientConfig{CAData: s.caData()},
+	}
+}
+
+// WriteKubeconfig writes a kubeconfig of the server into the file.
+// The current context is fake and the namespace is default.
+func (s *Server) WriteKubeconfig(name string) error {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["fake"] = &clientcmdapi.Cluster{Server: s.ts.URL, CertificateAuthorityData: s.caData()}
+	config.AuthInfos["fake"] = &clientcmdapi.AuthInfo{Token: "fake"}
+	config.Contexts["fake"] = &clientcmdapi.Context{Cluster: "fake", AuthInfo: "fake", Namespace: "default"}
+	config.CurrentContext = "fake"
+	if err := clientcmd.WriteToFile(*config, name); err != nil {
+		return fmt.Errorf("could not write the kubeconfig: %w", err)
+	}
+	return nil
+}
+
+// Pods returns the existing pods in order of namespace and name.
+func (s *Server) Pods() []corev1.Pod {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pods []corev1.Pod
+	for _, p := range s.pods {
+		pods = append(pods, *p.obj.DeepCopy())
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return podKey(pods[i].Namespace, pods[i].Name) < podKey(pods[j].Namespace, pods[j].Name)
+	})
+	return pods
+}
+
+// Events returns the created events.
+func (s *Server) Events() []corev1.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]corev1.Event{}, s.events...)
+}
+
+func podKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func (s *Server) getPod(namespace, name string) *pod {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pods[podKey(namespace, name)]
+}
+
+// ServeHTTP routes a request of the API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" && r.Method == http.MethodPost {
+		s.createSelfSubjectAccessReview(w, r)
+		return
+	}
+	// /api/v1/namespaces/NAMESPACE/RESOURCE[/NAME[/SUBRESOURCE]]
+	p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(p) < 5 || p[0] != "api" || p[1] != "v1" || p[2] != "namespaces" {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		return
+	}
+	namespace, resource := p[3], p[4]
+	switch {
+	case resource == "pods" && len(p) == 5 && r.Method == http.MethodGet:
+		s.listPods(w, r, namespace)
+	case resource == "pods" && len(p) == 5 && r.Method == http.MethodPost:
+		s.createPod(w, r, namespace)
+	case resource == "pods" && len(p) == 6 && r.Method == http.MethodGet:
+		s.getPodHandler(w, namespace, p[5])
+	case resource == "pods" && len(p) == 6 && r.Method == http.MethodDelete:
+		s.deletePod(w, namespace, p[5])
+	case resource == "pods" && len(p) == 7 && p[6] == "log" && r.Method == http.MethodGet:
+		s.podLogs(w, r, namespace, p[5])
+	case resource == "pods" && len(p) == 7 && p[6] == "portforward":
+		s.portForward(w, r, namespace, p[5])
+	case resource == "events" && len(p) == 5 && r.Method == http.MethodPost:
+		s.createEvent(w, r, namespace)
+	default:
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{Resource: resource}, r.URL.Path))
+	}
+}
+
+func (s *Server) listPods(w http.ResponseWriter, r *http.Request, namespace string) {
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	list := &corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}}
+	for _, pod := range s.Pods() {
+		if pod.Namespace == namespace && selector.Matches(labels.Set(pod.Labels)) {
+			list.Items = append(list.Items, pod)
+		}
+	}
+	writeObject(w, http.StatusOK, list)
+}
+
+func (s *Server) createPod(w http.ResponseWriter, r *http.Request, namespace string) {
+	var obj corev1.Pod
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	obj.TypeMeta = metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+	obj.Namespace = namespace
+	if obj.Name == "" {
+		obj.Name = obj.GenerateName + utilrand.String(5)
+	}
+	obj.UID = types.UID(utilrand.String(16))
+	obj.ResourceVersion = "1"
+	obj.CreationTimestamp = metav1.Now()
+	obj.Spec.NodeName = NodeName
+	obj.Status.Phase = s.PodPhase
+	if obj.Status.Phase == "" {
+		obj.Status.Phase = corev1.PodRunning
+	}
+	obj.Status.PodIP = "127.0.0.1"
+
+	logs := newLogBuffer()
+	px, err := newProxy(&obj, logs)
+	if err != nil {
+		// a real pod would fail if Envoy could not start
+		obj.Status.Phase = corev1.PodFailed
+		obj.Status.Message = err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := podKey(obj.Namespace, obj.Name)
+	if s.pods[key] != nil {
+		writeError(w, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "pods"}, obj.Name))
+		return
+	}
+	s.pods[key] = &pod{obj: &obj, proxy: px, logs: logs, deleted: make(chan struct{})}
+	writeObject(w, http.StatusCreated, &obj)
+}
+
+func (s *Server) getPodHandler(w http.ResponseWriter, namespace, name string) {
+	p := s.getPod(namespace, name)
+	if p == nil {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name))
+		return
+	}
+	s.mu.Lock()
+	obj := p.obj.DeepCopy()
+	s.mu.Unlock()
+	writeObject(w, http.StatusOK, obj)
+}
+
+func (s *Server) deletePod(w http.ResponseWriter, namespace, name string) {
+	s.mu.Lock()
+	key := podKey(namespace, name)
+	p := s.pods[key]
+	delete(s.pods, key)
+	s.mu.Unlock()
+	if p == nil {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name))
+		return
+	}
+	p.stop()
+	writeObject(w, http.StatusOK, p.obj)
+}
+
+// stop terminates the logs and port-forwarding of the pod.
+func (p *pod) stop() {
+	p.proxy.stop()
+	p.logs.close()
+	close(p.deleted)
+}
+
+// podLogs writes the logs of the pod.
+// If follow is set, it keeps writing until the pod is deleted or the client disconnects.
+func (s *Server) podLogs(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	p := s.getPod(namespace, name)
+	if p == nil {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name))
+		return
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+	w.Header().Set("content-type", "text/plain")
+	flusher, _ := w.(http.Flusher)
+	var n int
+	for {
+		lines, changed, closed := p.logs.since(n)
+		n += len(lines)
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !follow || closed {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (s *Server) createEvent(w http.ResponseWriter, r *http.Request, namespace string) {
+	var obj corev1.Event
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	obj.TypeMeta = metav1.TypeMeta{Kind: "Event", APIVersion: "v1"}
+	obj.Namespace = namespace
+	if obj.Name == "" {
+		obj.Name = obj.GenerateName + utilrand.String(5)
+	}
+	s.mu.Lock()
+	s.events = append(s.events, obj)
+	s.mu.Unlock()
+	writeObject(w, http.StatusCreated, &obj)
+}
+
+// createSelfSubjectAccessReview allows any access.
+func (s *Server) createSelfSubjectAccessReview(w http.ResponseWriter, r *http.Request) {
+	var obj authorizationv1.SelfSubjectAccessReview
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	obj.TypeMeta = metav1.TypeMeta{Kind: "SelfSubjectAccessReview", APIVersion: "authorization.k8s.io/v1"}
+	obj.Status.Allowed = true
+	writeObject(w, http.StatusCreated, &obj)
+}
+
+func writeObject(w http.ResponseWriter, code int, obj runtime.Object) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+func writeError(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	writeObject(w, int(status.Code), &status)
+}
+
+// logBuffer holds the log lines of a pod.
+type logBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	changed chan struct{}
+	closed  bool
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{changed: make(chan struct{})}
+}
+
+// printf appends a line.
+func (b *logBuffer) printf(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.lines = append(b.lines, fmt.Sprintf(format, args...))
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *logBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.changed)
+}
+
+// since returns the lines after the index.
+// The channel is closed when a line is appended or the buffer is closed.
+func (b *logBuffer) since(i int) ([]string, <-chan struct{}, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.lines[i:]...), b.changed, b.closed
+}
+
+// accessLog returns a line of the access log in the format of the Envoy config.
+func accessLog(index int, start time.Time, received, sent int64, upstream, flags string) string {
+	b, _ := json.Marshal(map[string]string{
+		"tunnel":         fmt.Sprint(index),
+		"start_time":     start.UTC().Format(time.RFC3339Nano),
+		"duration":       fmt.Sprint(time.Since(start).Milliseconds()),
+		"bytes_received": fmt.Sprint(received),
+		"bytes_sent":     fmt.Sprint(sent),
+		"upstream_host":  upstream,
+		"response_flags": flags,
+	})
+	return string(b)
+}
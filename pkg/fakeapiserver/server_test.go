@@ -0,0 +1,195 @@
+package fakeapiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// createPod creates a pod which forwards the container port 10000 to the upstream.
+func createPod(t *testing.T, s *Server, upstream *net.TCPAddr) *corev1.Pod {
+	t.Helper()
+	config, err := envoy.NewConfig([]tunnel.Tunnel{
+		{RemoteHost: upstream.IP.String(), RemotePort: upstream.Port, ContainerPort: 10000},
+	}, envoy.Option{})
+	if err != nil {
+		t.Fatalf("envoy.NewConfig error: %s", err)
+	}
+	c, err := kubernetes.NewForConfig(s.Config())
+	if err != nil {
+		t.Fatalf("NewForConfig error: %s", err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "envoy-"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "envoy", Args: []string{"--config-yaml", config}}},
+		},
+	}
+	pod, err = c.CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Create error: %s", err)
+	}
+	return pod
+}
+
+func TestServer_Pods(t *testing.T) {
+	ctx := context.TODO()
+	s := New()
+	defer s.Close()
+	c, err := kubernetes.NewForConfig(s.Config())
+	if err != nil {
+		t.Fatalf("NewForConfig error: %s", err)
+	}
+	pod := createPod(t, s, StartEchoServer(t))
+	if !strings.HasPrefix(pod.Name, "envoy-") {
+		t.Errorf("name wants generated but got %s", pod.Name)
+	}
+
+	got, err := c.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if got.Status.Phase != corev1.PodRunning {
+		t.Errorf("phase wants Running but got %s", got.Status.Phase)
+	}
+	if got.Spec.NodeName != NodeName {
+		t.Errorf("node wants %s but got %s", NodeName, got.Spec.NodeName)
+	}
+
+	if err := c.CoreV1().Pods("default").Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+	if _, err := c.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("Get wants NotFound but got nil")
+	}
+	if pods := s.Pods(); len(pods) != 0 {
+		t.Errorf("pods want none but got %d", len(pods))
+	}
+}
+
+func TestServer_PortForwardSPDY(t *testing.T) {
+	ctx := context.TODO()
+	s := New()
+	defer s.Close()
+	pod := createPod(t, s, StartEchoServer(t))
+	var pf portforwarder.PortForwarder
+
+	t.Run("Echo", func(t *testing.T) {
+		conn, err := pf.Dial(ctx, portforwarder.StreamOption{
+			Config:              s.Config(),
+			TargetNamespace:     pod.Namespace,
+			TargetPodName:       pod.Name,
+			TargetContainerPort: 10000,
+		})
+		if err != nil {
+			t.Fatalf("Dial error: %s", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("Write error: %s", err)
+		}
+		if err := conn.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+			t.Fatalf("CloseWrite error: %s", err)
+		}
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("ReadAll error: %s", err)
+		}
+		if diff := cmp.Diff("hello", string(b)); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("UnknownPort", func(t *testing.T) {
+		var out strings.Builder
+		err := pf.Stream(ctx, portforwarder.StreamOption{
+			Config:              s.Config(),
+			TargetNamespace:     pod.Namespace,
+			TargetPodName:       pod.Name,
+			TargetContainerPort: 10001,
+		}, strings.NewReader("hello"), &out)
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("error wants connection refused but got %v", err)
+		}
+	})
+
+	t.Run("AccessLog", func(t *testing.T) {
+		c, err := kubernetes.NewForConfig(s.Config())
+		if err != nil {
+			t.Fatalf("NewForConfig error: %s", err)
+		}
+		b, err := c.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			t.Fatalf("GetLogs error: %s", err)
+		}
+		accessLog, ok := envoy.ParseAccessLog(strings.Split(string(b), "\n")[0])
+		if !ok {
+			t.Fatalf("logs want an access log but got %s", b)
+		}
+		if accessLog.BytesReceived != 5 || accessLog.BytesSent != 5 {
+			t.Errorf("bytes want 5/5 but got %d/%d", accessLog.BytesReceived, accessLog.BytesSent)
+		}
+	})
+}
+
+func TestServer_PortForwardWebSocket(t *testing.T) {
+	s := New()
+	defer s.Close()
+	pod := createPod(t, s, StartEchoServer(t))
+
+	config, err := websocket.NewConfig(
+		strings.Replace(s.URL(), "https://", "wss://", 1)+"/api/v1/namespaces/default/pods/"+pod.Name+"/portforward?ports=10000",
+		s.URL())
+	if err != nil {
+		t.Fatalf("NewConfig error: %s", err)
+	}
+	config.Protocol = []string{"v4.channel.k8s.io"}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(s.caData())
+	config.TlsConfig = &tls.Config{RootCAs: roots}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("DialConfig error: %s", err)
+	}
+	defer ws.Close()
+
+	receive := func() (byte, []byte) {
+		t.Helper()
+		var message []byte
+		if err := websocket.Message.Receive(ws, &message); err != nil {
+			t.Fatalf("Receive error: %s", err)
+		}
+		return message[0], message[1:]
+	}
+	// the port is announced on the data and error channels
+	for _, wantChannel := range []byte{0, 1} {
+		channel, b := receive()
+		if channel != wantChannel || binary.LittleEndian.Uint16(b) != 10000 {
+			t.Errorf("channel %d wants port 10000 but got %d: %v", wantChannel, channel, b)
+		}
+	}
+	if err := websocket.Message.Send(ws, append([]byte{0}, "hello"...)); err != nil {
+		t.Fatalf("Send error: %s", err)
+	}
+	channel, b := receive()
+	if channel != 0 {
+		t.Errorf("channel wants 0 but got %d", channel)
+	}
+	if diff := cmp.Diff("hello", string(b)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
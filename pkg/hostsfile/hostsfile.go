@@ -0,0 +1,116 @@
+// Package hostsfile provides management of a marked block in the hosts file.
+package hostsfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Entry represents a line of the hosts file.
+type Entry struct {
+	IP       string
+	Hostname string
+}
+
+// Add writes the entries into the block of the hosts file.
+// If the block already exists, it is replaced.
+func Add(path, id string, entries []Entry) error {
+	return update(path, func(content string) string {
+		return addBlock(content, id, entries)
+	})
+}
+
+// Remove removes the block from the hosts file.
+func Remove(path, id string) error {
+	return update(path, func(content string) string {
+		return removeBlock(content, id)
+	})
+}
+
+// RemoveStale removes the blocks which are not alive.
+// It is intended to clean up the blocks left by a crashed process.
+// It returns the IDs of the removed blocks.
+func RemoveStale(path string, alive func(id string) bool) ([]string, error) {
+	var removed []string
+	err := update(path, func(content string) string {
+		for _, id := range blockIDs(content) {
+			if !alive(id) {
+				content = removeBlock(content, id)
+				removed = append(removed, id)
+			}
+		}
+		return content
+	})
+	return removed, err
+}
+
+func update(path string, f func(content string) string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("could not stat the hosts file: %w", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read the hosts file: %w", err)
+	}
+	// write in place, because the hosts file may be a bind mount
+	if err := os.WriteFile(path, []byte(f(string(b))), st.Mode()); err != nil {
+		return fmt.Errorf("could not write the hosts file: %w", err)
+	}
+	return nil
+}
+
+func beginMarker(id string) string {
+	return fmt.Sprintf("# BEGIN kubectl-external-forward %s", id)
+}
+
+func endMarker(id string) string {
+	return fmt.Sprintf("# END kubectl-external-forward %s", id)
+}
+
+// blockIDs returns the IDs of the blocks in the content.
+func blockIDs(content string) []string {
+	prefix := beginMarker("")
+	var ids []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, prefix) {
+			ids = append(ids, strings.TrimPrefix(line, prefix))
+		}
+	}
+	return ids
+}
+
+func addBlock(content, id string, entries []Entry) string {
+	var b strings.Builder
+	b.WriteString(removeBlock(content, id))
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(beginMarker(id) + "\n")
+	for _, e := range entries {
+		b.WriteString(fmt.Sprintf("%s\t%s\n", e.IP, e.Hostname))
+	}
+	b.WriteString(endMarker(id) + "\n")
+	return b.String()
+}
+
+func removeBlock(content, id string) string {
+	var b strings.Builder
+	inBlock := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case beginMarker(id):
+			inBlock = true
+			continue
+		case endMarker(id):
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
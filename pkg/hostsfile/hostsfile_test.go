@@ -0,0 +1,115 @@
+package hostsfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddBlock(t *testing.T) {
+	entries := []Entry{
+		{IP: "127.0.0.2", Hostname: "db.staging"},
+		{IP: "127.0.0.3", Hostname: "api.staging"},
+	}
+	t.Run("Empty", func(t *testing.T) {
+		want := `# BEGIN kubectl-external-forward 100
+127.0.0.2	db.staging
+127.0.0.3	api.staging
+# END kubectl-external-forward 100
+`
+		got := addBlock("", "100", entries)
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		content := `127.0.0.1	localhost`
+		want := `127.0.0.1	localhost
+# BEGIN kubectl-external-forward 100
+127.0.0.2	db.staging
+127.0.0.3	api.staging
+# END kubectl-external-forward 100
+`
+		got := addBlock(content, "100", entries)
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		content := `127.0.0.1	localhost
+# BEGIN kubectl-external-forward 100
+127.0.0.2	old.staging
+# END kubectl-external-forward 100
+# BEGIN kubectl-external-forward 200
+127.0.0.9	other.staging
+# END kubectl-external-forward 200
+`
+		want := `127.0.0.1	localhost
+# BEGIN kubectl-external-forward 200
+127.0.0.9	other.staging
+# END kubectl-external-forward 200
+# BEGIN kubectl-external-forward 100
+127.0.0.2	db.staging
+127.0.0.3	api.staging
+# END kubectl-external-forward 100
+`
+		got := addBlock(content, "100", entries)
+		if got != want {
+			t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+		}
+	})
+}
+
+func TestRemoveBlock(t *testing.T) {
+	content := `127.0.0.1	localhost
+# BEGIN kubectl-external-forward 100
+127.0.0.2	db.staging
+# END kubectl-external-forward 100
+::1	localhost
+`
+	want := `127.0.0.1	localhost
+::1	localhost
+`
+	got := removeBlock(content, "100")
+	if got != want {
+		t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	content := `127.0.0.1	localhost
+# BEGIN kubectl-external-forward 100
+127.0.0.2	crashed.staging
+# END kubectl-external-forward 100
+# BEGIN kubectl-external-forward 200
+127.0.0.9	running.staging
+# END kubectl-external-forward 200
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	removed, err := RemoveStale(path, func(id string) bool { return id == "200" })
+	if err != nil {
+		t.Fatalf("RemoveStale error: %s", err)
+	}
+	if diff := cmp.Diff([]string{"100"}, removed); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile error: %s", err)
+	}
+	want := `127.0.0.1	localhost
+# BEGIN kubectl-external-forward 200
+127.0.0.9	running.staging
+# END kubectl-external-forward 200
+`
+	if got := string(b); got != want {
+		t.Errorf("got != want:\n%s", cmp.Diff(got, want))
+	}
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// FetchFunc returns the stats of Envoy in the Prometheus text format.
+type FetchFunc func(ctx context.Context) (io.ReadCloser, error)
+
+const envoyScrapeTimeout = 10 * time.Second
+
+// envoyGatherer scrapes the stats of Envoy in each pod.
+// It adds the pod label to the metrics.
+type envoyGatherer struct {
+	mu      sync.Mutex
+	targets map[string]FetchFunc
+}
+
+func (g *envoyGatherer) add(pod string, fetch FetchFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.targets == nil {
+		g.targets = make(map[string]FetchFunc)
+	}
+	g.targets[pod] = fetch
+}
+
+func (g *envoyGatherer) remove(pod string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.targets, pod)
+}
+
+func (g *envoyGatherer) snapshot() map[string]FetchFunc {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	targets := make(map[string]FetchFunc, len(g.targets))
+	for pod, fetch := range g.targets {
+		targets[pod] = fetch
+	}
+	return targets
+}
+
+// Gather implements prometheus.Gatherer.
+// If a pod could not be scraped, it skips the pod.
+func (g *envoyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), envoyScrapeTimeout)
+	defer cancel()
+	merged := make(map[string]*dto.MetricFamily)
+	for pod, fetch := range g.snapshot() {
+		families, err := scrapeEnvoy(ctx, fetch)
+		if err != nil {
+			klog.V(1).Infof("could not scrape envoy of pod %s: %s", pod, err)
+			continue
+		}
+		for name, mf := range families {
+			for _, m := range mf.Metric {
+				m.Label = append(m.Label, &dto.LabelPair{Name: proto.String("pod"), Value: proto.String(pod)})
+			}
+			if existing := merged[name]; existing != nil {
+				existing.Metric = append(existing.Metric, mf.Metric...)
+				continue
+			}
+			merged[name] = mf
+		}
+	}
+	var names []string
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []*dto.MetricFamily
+	for _, name := range names {
+		result = append(result, merged[name])
+	}
+	return result, nil
+}
+
+func scrapeEnvoy(ctx context.Context, fetch FetchFunc) (map[string]*dto.MetricFamily, error) {
+	body, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(body)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the stats: %w", err)
+	}
+	return families, nil
+}
@@ -0,0 +1,167 @@
+// Package metrics provides Prometheus metrics of the tunnels.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+const namespace = "kubectl_external_forward"
+
+// Metrics holds the metrics of the tunnels.
+// All methods are no-op if the receiver is nil.
+type Metrics struct {
+	registry          *prometheus.Registry
+	activeConnections *prometheus.GaugeVec
+	transferredBytes  *prometheus.CounterVec
+	reconnects        *prometheus.CounterVec
+	portForwardErrors *prometheus.CounterVec
+	podStartup        prometheus.Histogram
+	envoy             *envoyGatherer
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		activeConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_connections",
+			Help:      "Number of the connections in progress.",
+		}, []string{"tunnel"}),
+		transferredBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transferred_bytes_total",
+			Help:      "Number of the bytes transferred. Direction is sent (local to pod) or received (pod to local).",
+		}, []string{"tunnel", "direction"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Number of the reconnections to the pod.",
+		}, []string{"tunnel"}),
+		portForwardErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "port_forward_errors_total",
+			Help:      "Number of the errors of port-forwarding.",
+		}, []string{"tunnel"}),
+		podStartup: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "pod_startup_seconds",
+			Help:      "Time from creating a pod to running.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+		}),
+		envoy: &envoyGatherer{},
+	}
+	m.registry.MustRegister(
+		m.activeConnections,
+		m.transferredBytes,
+		m.reconnects,
+		m.portForwardErrors,
+		m.podStartup,
+	)
+	return m
+}
+
+// Observer returns an observer which records the metrics of the tunnel.
+func (m *Metrics) Observer(tunnel string) portforwarder.Observer {
+	if m == nil {
+		return nil
+	}
+	return &observer{m: m, tunnel: tunnel}
+}
+
+// PortForwardError records an error of the port-forwarder.
+func (m *Metrics) PortForwardError(tunnel string) {
+	if m == nil {
+		return
+	}
+	m.portForwardErrors.WithLabelValues(tunnel).Inc()
+}
+
+// PodStartup records the time from creating a pod to running.
+func (m *Metrics) PodStartup(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.podStartup.Observe(d.Seconds())
+}
+
+// AddEnvoy adds the Envoy of the pod to the targets of scraping.
+func (m *Metrics) AddEnvoy(pod string, fetch FetchFunc) {
+	if m == nil {
+		return
+	}
+	m.envoy.add(pod, fetch)
+}
+
+// RemoveEnvoy removes the Envoy of the pod from the targets of scraping.
+func (m *Metrics) RemoveEnvoy(pod string) {
+	if m == nil {
+		return
+	}
+	m.envoy.remove(pod)
+}
+
+func (m *Metrics) gatherer() prometheus.Gatherer {
+	return prometheus.Gatherers{m.registry, m.envoy}
+}
+
+// Serve runs a HTTP server which exposes the metrics on /metrics.
+// It stops the server when ctx is done.
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.gatherer(), promhttp.HandlerOpts{}))
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", addr, err)
+	}
+	s := http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	klog.Infof("serving metrics on http://%s/metrics", l.Addr())
+	if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics server error: %w", err)
+	}
+	return nil
+}
+
+type observer struct {
+	m      *Metrics
+	tunnel string
+}
+
+func (o *observer) Ready(net.Addr) {}
+
+func (o *observer) ConnectionOpened(net.Addr) {
+	o.m.activeConnections.WithLabelValues(o.tunnel).Inc()
+}
+
+func (o *observer) ConnectionClosed(net.Addr) {
+	o.m.activeConnections.WithLabelValues(o.tunnel).Dec()
+}
+
+func (o *observer) BytesSent(n int) {
+	o.m.transferredBytes.WithLabelValues(o.tunnel, "sent").Add(float64(n))
+}
+
+func (o *observer) BytesReceived(n int) {
+	o.m.transferredBytes.WithLabelValues(o.tunnel, "received").Add(float64(n))
+}
+
+func (o *observer) ConnectionError(error) {
+	o.m.portForwardErrors.WithLabelValues(o.tunnel).Inc()
+}
+
+func (o *observer) Reconnecting() {
+	o.m.reconnects.WithLabelValues(o.tunnel).Inc()
+}
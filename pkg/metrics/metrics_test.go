@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Observer", func(t *testing.T) {
+		m := New()
+		o := m.Observer("127.0.0.1:15432")
+		client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+		o.ConnectionOpened(client)
+		o.BytesSent(10)
+		o.BytesReceived(20)
+		o.BytesReceived(30)
+		o.ConnectionOpened(client)
+		o.ConnectionClosed(client)
+		o.Reconnecting()
+		want := `
+# HELP kubectl_external_forward_active_connections Number of the connections in progress.
+# TYPE kubectl_external_forward_active_connections gauge
+kubectl_external_forward_active_connections{tunnel="127.0.0.1:15432"} 1
+# HELP kubectl_external_forward_reconnects_total Number of the reconnections to the pod.
+# TYPE kubectl_external_forward_reconnects_total counter
+kubectl_external_forward_reconnects_total{tunnel="127.0.0.1:15432"} 1
+# HELP kubectl_external_forward_transferred_bytes_total Number of the bytes transferred. Direction is sent (local to pod) or received (pod to local).
+# TYPE kubectl_external_forward_transferred_bytes_total counter
+kubectl_external_forward_transferred_bytes_total{direction="received",tunnel="127.0.0.1:15432"} 50
+kubectl_external_forward_transferred_bytes_total{direction="sent",tunnel="127.0.0.1:15432"} 10
+`
+		if err := testutil.GatherAndCompare(m.gatherer(), strings.NewReader(want),
+			"kubectl_external_forward_active_connections",
+			"kubectl_external_forward_reconnects_total",
+			"kubectl_external_forward_transferred_bytes_total",
+		); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Envoy", func(t *testing.T) {
+		m := New()
+		stats := `# TYPE envoy_cluster_upstream_cx_total counter
+envoy_cluster_upstream_cx_total{envoy_cluster_name="cluster_0"} 3
+`
+		fetch := func(ctx context.Context) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(stats)), nil
+		}
+		m.AddEnvoy("default/pod-a", fetch)
+		m.AddEnvoy("default/pod-b", fetch)
+		m.AddEnvoy("default/pod-c", func(ctx context.Context) (io.ReadCloser, error) {
+			return nil, io.ErrUnexpectedEOF
+		})
+		want := `
+# HELP envoy_cluster_upstream_cx_total 
+# TYPE envoy_cluster_upstream_cx_total counter
+envoy_cluster_upstream_cx_total{envoy_cluster_name="cluster_0",pod="default/pod-a"} 3
+envoy_cluster_upstream_cx_total{envoy_cluster_name="cluster_0",pod="default/pod-b"} 3
+`
+		if err := testutil.GatherAndCompare(m.gatherer(), strings.NewReader(want), "envoy_cluster_upstream_cx_total"); err != nil {
+			t.Error(err)
+		}
+
+		m.RemoveEnvoy("default/pod-b")
+		want = `
+# HELP envoy_cluster_upstream_cx_total 
+# TYPE envoy_cluster_upstream_cx_total counter
+envoy_cluster_upstream_cx_total{envoy_cluster_name="cluster_0",pod="default/pod-a"} 3
+`
+		if err := testutil.GatherAndCompare(m.gatherer(), strings.NewReader(want), "envoy_cluster_upstream_cx_total"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		var m *Metrics
+		if o := m.Observer("127.0.0.1:15432"); o != nil {
+			t.Errorf("Observer wants nil but got %v", o)
+		}
+		m.PortForwardError("127.0.0.1:15432")
+		m.RemoveEnvoy("default/pod-a")
+	})
+}
@@ -0,0 +1,109 @@
+// Package policy provides the allowlist of destinations.
+package policy
+
+import (
+	"fmt"
+	"net"
+	"path"
+
+	"sigs.k8s.io/yaml"
+)
+
+// DataKey is the key of the policy in the ConfigMap.
+const DataKey = "policy.yaml"
+
+// Policy represents the allowlist of destinations.
+// A destination is allowed if any rule matches it.
+type Policy struct {
+	// Version is recorded in the annotation of the pod.
+	// If empty, the resource version of the ConfigMap is used.
+	Version string `json:"version,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule represents a set of allowed destinations.
+// An empty field matches any value.
+type Rule struct {
+	// Hosts are the patterns of remote hosts, such as *.staging.internal or 10.0.0.0/8.
+	// A pattern is a CIDR or the syntax of path.Match, hence * does not match the / of svc/NAME.
+	// Use svc/* to allow the Kubernetes services.
+	Hosts []string `json:"hosts,omitempty"`
+	Ports []int    `json:"ports,omitempty"`
+	// Namespaces are the patterns of namespaces of the pod
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Parse parses the policy in YAML.
+func Parse(b []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, err
+	}
+	for i, r := range p.Rules {
+		for _, pattern := range r.Hosts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rules[%d]: invalid host pattern %s: %w", i, pattern, err)
+			}
+		}
+		for _, pattern := range r.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rules[%d]: invalid namespace pattern %s: %w", i, pattern, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Allow returns an error if no rule allows the destination.
+func (p Policy) Allow(namespace, host string, port int) error {
+	for _, r := range p.Rules {
+		if r.match(namespace, host, port) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s:%d from namespace %s is not allowed by the policy", host, port, namespace)
+}
+
+func (r Rule) match(namespace, host string, port int) bool {
+	return matchAny(r.Namespaces, namespace, matchPattern) &&
+		matchAny(r.Hosts, host, matchHost) &&
+		matchPort(r.Ports, port)
+}
+
+func matchAny(patterns []string, s string, match func(pattern, s string) bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, s string) bool {
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// matchHost matches the host to a CIDR or a pattern of hostnames.
+func matchHost(pattern, host string) bool {
+	if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && ipNet.Contains(ip)
+	}
+	return matchPattern(pattern, host)
+}
+
+func matchPort(ports []int, port int) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
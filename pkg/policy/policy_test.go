@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestPolicy_Allow(t *testing.T) {
+	p, err := Parse([]byte(`
+version: "2"
+rules:
+  - hosts: ["*.staging.internal", "10.0.0.0/8"]
+    ports: [5432, 443]
+  - hosts: ["svc/*"]
+    namespaces: ["dev-*"]
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	if p.Version != "2" {
+		t.Errorf("Version wants 2 but got %s", p.Version)
+	}
+	for _, c := range []struct {
+		namespace string
+		host      string
+		port      int
+		allowed   bool
+	}{
+		{"default", "db.staging.internal", 5432, true},
+		{"default", "db.staging.internal", 22, false},
+		{"default", "db.production.internal", 5432, false},
+		{"default", "10.1.2.3", 443, true},
+		{"default", "192.168.0.1", 443, false},
+		{"dev-alice", "svc/db", 5432, true},
+		{"default", "svc/db", 5432, false},
+	} {
+		err := p.Allow(c.namespace, c.host, c.port)
+		if (err == nil) != c.allowed {
+			t.Errorf("Allow(%s, %s, %d) wants allowed=%v but got %v", c.namespace, c.host, c.port, c.allowed, err)
+		}
+	}
+}
+
+func TestPolicy_Allow_wildcard(t *testing.T) {
+	// a wildcard does not match the separator of svc/NAME
+	p, err := Parse([]byte(`rules: [{hosts: ["*"], namespaces: ["default"]}, {hosts: ["svc/*"], namespaces: ["dev"]}]`))
+	if err != nil {
+		t.Fatalf("Parse error: %s", err)
+	}
+	for _, c := range []struct {
+		namespace string
+		host      string
+		allowed   bool
+	}{
+		{"default", "db.staging.internal", true},
+		{"default", "svc/db", false},
+		{"default", "svc/db.app", false},
+		{"dev", "svc/db", true},
+		{"dev", "svc/db.app", true},
+		{"dev", "db.staging.internal", false},
+	} {
+		err := p.Allow(c.namespace, c.host, 5432)
+		if (err == nil) != c.allowed {
+			t.Errorf("Allow(%s, %s) wants allowed=%v but got %v", c.namespace, c.host, c.allowed, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Run("InvalidPattern", func(t *testing.T) {
+		if _, err := Parse([]byte(`rules: [{hosts: ["[a-"]}]`)); err == nil {
+			t.Errorf("Parse wants error but got nil")
+		}
+	})
+	t.Run("UnknownField", func(t *testing.T) {
+		if _, err := Parse([]byte(`rules: [{host: "example.com"}]`)); err == nil {
+			t.Errorf("Parse wants error but got nil")
+		}
+	})
+}
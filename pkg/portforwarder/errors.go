@@ -0,0 +1,87 @@
+package portforwarder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrorKind represents the cause of an error of the port forwarder.
+type ErrorKind string
+
+const (
+	// UnknownError is an error which is not classified
+	UnknownError ErrorKind = "unknown"
+	// AuthError is returned if the user is not authenticated or not allowed to forward the port
+	AuthError ErrorKind = "auth"
+	// PodGoneError is returned if the pod does not exist or is not running
+	PodGoneError ErrorKind = "pod_gone"
+	// PortInUseError is returned if the local port is in use
+	PortInUseError ErrorKind = "port_in_use"
+	// NetworkError is returned if the API server is not reachable or the connection is lost
+	NetworkError ErrorKind = "network"
+)
+
+// Error is an error of the port forwarder with the kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the error.
+// It returns UnknownError if the error is not an *Error.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return UnknownError
+}
+
+// newListenError returns an error of listening on the local address.
+func newListenError(addr string, err error) error {
+	kind := UnknownError
+	if errors.Is(err, syscall.EADDRINUSE) {
+		kind = PortInUseError
+	}
+	return &Error{Kind: kind, Err: fmt.Errorf("could not listen on %s: %w", addr, err)}
+}
+
+// newDialError returns an error of upgrading the connection to the pod.
+func newDialError(err error) error {
+	return &Error{Kind: classifyDialError(err), Err: fmt.Errorf("could not upgrade the connection: %w", err)}
+}
+
+func classifyDialError(err error) ErrorKind {
+	switch {
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return AuthError
+	case apierrors.IsNotFound(err), apierrors.IsGone(err):
+		return PodGoneError
+	case apierrors.IsServiceUnavailable(err), apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err):
+		return NetworkError
+	}
+	// kubelet returns an error message without status
+	message := strings.ToLower(err.Error())
+	if strings.Contains(message, "pod does not exist") || strings.Contains(message, "is not running") {
+		return PodGoneError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNREFUSED) {
+		return NetworkError
+	}
+	return UnknownError
+}
@@ -5,8 +5,11 @@
 package mock_portforwarder
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	portforwarder "github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	io "io"
+	net "net"
 	reflect "reflect"
 )
 
@@ -33,16 +36,45 @@ func (m *MockInterface) EXPECT() *MockInterfaceMockRecorder {
 	return m.recorder
 }
 
+// Dial mocks base method
+func (m *MockInterface) Dial(arg0 context.Context, arg1 portforwarder.StreamOption) (net.Conn, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Dial", arg0, arg1)
+	ret0, _ := ret[0].(net.Conn)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Dial indicates an expected call of Dial
+func (mr *MockInterfaceMockRecorder) Dial(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dial", reflect.TypeOf((*MockInterface)(nil).Dial), arg0, arg1)
+}
+
 // Run mocks base method
-func (m *MockInterface) Run(arg0 portforwarder.Option, arg1 chan struct{}, arg2 <-chan struct{}) error {
+func (m *MockInterface) Run(arg0 context.Context, arg1 portforwarder.Option) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Run", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "Run", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Run indicates an expected call of Run
-func (mr *MockInterfaceMockRecorder) Run(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockInterfaceMockRecorder) Run(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockInterface)(nil).Run), arg0, arg1)
+}
+
+// Stream mocks base method
+func (m *MockInterface) Stream(arg0 context.Context, arg1 portforwarder.StreamOption, arg2 io.Reader, arg3 io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stream", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Stream indicates an expected call of Stream
+func (mr *MockInterfaceMockRecorder) Stream(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockInterface)(nil).Run), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stream", reflect.TypeOf((*MockInterface)(nil).Stream), arg0, arg1, arg2, arg3)
 }
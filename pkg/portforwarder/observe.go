@@ -0,0 +1,47 @@
+package portforwarder
+
+import (
+	"net"
+	"sync"
+)
+
+// observeConn returns a connection which notifies the observer of the events.
+// It returns the connection as-is if the observer is nil.
+func observeConn(conn net.Conn, observer Observer) net.Conn {
+	if observer == nil {
+		return conn
+	}
+	observer.ConnectionOpened(conn.RemoteAddr())
+	return &observedConn{Conn: conn, observer: observer}
+}
+
+// observedConn is a local connection which notifies the observer.
+// Reading from the connection is sending to the pod, and writing is receiving from the pod.
+type observedConn struct {
+	net.Conn
+	observer  Observer
+	closeOnce sync.Once
+}
+
+func (c *observedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.observer.BytesSent(n)
+	}
+	return n, err
+}
+
+func (c *observedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.observer.BytesReceived(n)
+	}
+	return n, err
+}
+
+func (c *observedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.observer.ConnectionClosed(c.Conn.RemoteAddr())
+	})
+	return c.Conn.Close()
+}
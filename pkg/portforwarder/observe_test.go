@@ -0,0 +1,92 @@
+package portforwarder
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// recordObserver records the events.
+type recordObserver struct {
+	mu     sync.Mutex
+	events []string
+	errs   []error
+	sent   int
+	recv   int
+}
+
+func (o *recordObserver) record(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordObserver) Ready(net.Addr)            { o.record("Ready") }
+func (o *recordObserver) ConnectionOpened(net.Addr) { o.record("ConnectionOpened") }
+func (o *recordObserver) ConnectionClosed(net.Addr) { o.record("ConnectionClosed") }
+func (o *recordObserver) Reconnecting()             { o.record("Reconnecting") }
+
+func (o *recordObserver) ConnectionError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, "ConnectionError")
+	o.errs = append(o.errs, err)
+}
+
+// snapshot returns a copy of the events and errors.
+func (o *recordObserver) snapshot() ([]string, []error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string{}, o.events...), append([]error{}, o.errs...)
+}
+
+func (o *recordObserver) BytesSent(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent += n
+}
+
+func (o *recordObserver) BytesReceived(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.recv += n
+}
+
+func TestObserveConn(t *testing.T) {
+	local, client := net.Pipe()
+	defer client.Close()
+	var observer recordObserver
+	conn := observeConn(local, &observer)
+
+	go func() {
+		_, _ = client.Write([]byte("hello"))
+		_, _ = io.ReadFull(client, make([]byte, 3))
+	}()
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("Read error: %s", err)
+	}
+	if _, err := conn.Write([]byte("bye")); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+	_ = conn.Close()
+	_ = conn.Close()
+
+	if diff := cmp.Diff([]string{"ConnectionOpened", "ConnectionClosed"}, observer.events); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if observer.sent != 5 || observer.recv != 3 {
+		t.Errorf("sent/received want 5/3 but got %d/%d", observer.sent, observer.recv)
+	}
+}
+
+func TestObserveConn_Nil(t *testing.T) {
+	local, client := net.Pipe()
+	defer client.Close()
+	defer local.Close()
+	if conn := observeConn(local, nil); conn != local {
+		t.Errorf("connection wants as-is but got %T", conn)
+	}
+}
@@ -1,13 +1,34 @@
 // Package portforwarder provides port forwarding between local and Kubernetes.
+//
+// It speaks the same SPDY protocol as portforward.PortForwarder of client-go,
+// that is, each connection has a pair of the error and data streams with the same request ID.
+// It does not wrap portforward.PortForwarder for the following reasons:
+//
+//   - portforward.PortForwarder always binds the local ports by itself,
+//     but the listeners need to be bound before the pod is created, and handed over on retry or in the lazy mode.
+//   - portforward.PortForwarder stops when the connection to the pod is lost,
+//     and it cannot be reconnected without closing the listeners, which allows another process to take the ports.
+//   - portforward.PortForwarder closes the listeners and connections at once,
+//     hence it cannot stop accepting new connections while the active connections are drained.
+//
+// The connections are observed by wrapping them, hence the forwarding does not depend on the observers.
 package portforwarder
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/wire"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
@@ -22,45 +43,340 @@ var Set = wire.NewSet(
 
 // Option represents an option of PortForwarder.
 type Option struct {
+	Config          *rest.Config
+	TargetNamespace string
+	TargetPodName   string
+	// Ports are forwarded over a connection to the pod.
+	// The ports share the lifecycle of the connection, that is, an error or reconnect affects all of them.
+	// Run a port forwarder for each port if they need to fail or reconnect independently.
+	Ports []PortPair
+	// If set, stop accepting new connections when the channel is closed,
+	// and return after the active connections are finished.
+	Drain <-chan struct{}
+	// If set, reconnect to the pod when a value is received.
+	// The connections in progress are aborted, because their streams belong to the previous connection.
+	// Close Drain instead to wait for them.
+	Reconnect <-chan struct{}
+	// If set, it is called with the bound ports when the port forwarder is ready
+	Ready func(ports []portforward.ForwardedPort)
+	// If set, it receives the messages of the port forwarder.
+	// Default to WriterLogger of os.Stdout and os.Stderr.
+	Logger Logger
+}
+
+func (o Option) logger() Logger {
+	if o.Logger == nil {
+		return WriterLogger{}
+	}
+	return o.Logger
+}
+
+// PortPair represents a pair of the local port and the port of the pod.
+type PortPair struct {
+	// SourceHost and SourcePort are the local address to listen on.
+	// If SourcePort is zero, an available port is allocated.
+	SourceHost string
+	SourcePort int
+	// If set, accept the connections on the listener instead of SourceHost and SourcePort.
+	// Run closes the listener on return.
+	Listener            net.Listener
+	TargetContainerPort int
+	// If set, it is notified of the connections of the port
+	Observer Observer
+}
+
+// Logger receives the messages of the port forwarder.
+// It must be safe for concurrent use.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WriterLogger writes the messages in the same format as kubectl port-forward.
+// Out and ErrOut default to os.Stdout and os.Stderr.
+type WriterLogger struct {
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+func (l WriterLogger) Infof(format string, args ...interface{}) {
+	out := l.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	_, _ = fmt.Fprintf(out, format+"\n", args...)
+}
+
+func (l WriterLogger) Errorf(format string, args ...interface{}) {
+	errOut := l.ErrOut
+	if errOut == nil {
+		errOut = os.Stderr
+	}
+	_, _ = fmt.Fprintf(errOut, format+"\n", args...)
+}
+
+// Observer receives the events of the forwarded connections.
+// It must be safe for concurrent use.
+type Observer interface {
+	// Ready is called when the port forwarder is listening on the address,
+	// and again when it has reconnected to the pod
+	Ready(addr net.Addr)
+	// ConnectionOpened is called when a client has connected
+	ConnectionOpened(client net.Addr)
+	// ConnectionClosed is called when the connection of the client has been closed
+	ConnectionClosed(client net.Addr)
+	// BytesSent is called when n bytes are sent from local to the pod
+	BytesSent(n int)
+	// BytesReceived is called when n bytes are received from the pod
+	BytesReceived(n int)
+	// ConnectionError is called when a connection could not be forwarded
+	ConnectionError(err error)
+	// Reconnecting is called when the connection to the pod has been lost
+	Reconnecting()
+}
+
+// StreamOption represents an option of PortForwarder.Stream.
+type StreamOption struct {
 	Config              *rest.Config
-	SourceHost          string
-	SourcePort          int
 	TargetNamespace     string
 	TargetPodName       string
 	TargetContainerPort int
 }
 
 type Interface interface {
-	Run(o Option, readyChan chan struct{}, stopChan <-chan struct{}) error
+	Run(ctx context.Context, o Option) error
+	Stream(ctx context.Context, o StreamOption, in io.Reader, out io.Writer) error
+	Dial(ctx context.Context, o StreamOption) (net.Conn, error)
 }
 
 type PortForwarder struct {
 }
 
-// Run executes a port forwarder.
+// Run executes a port forwarder of the ports.
+// If the connection to the pod has been lost, it reconnects to the pod.
 //
-// It returns nil if stopChan has been closed or connection has lost.
-// It returns an error if it could not connect to the pod.
+// It returns nil if ctx has been canceled.
+// If o.Drain has been closed, it stops accepting and returns nil after the active connections are finished.
+// If the connection has been lost or o.Reconnect has received a value,
+// it aborts the active connections and reconnects to the pod.
+// It returns an *Error if it could not listen on a port or connect to the pod.
 //
-// It will close the readyChan when the port forwarder is ready.
-// Caller can stop the port forwarder by closing the stopChan.
-func (pf *PortForwarder) Run(o Option, readyChan chan struct{}, stopChan <-chan struct{}) error {
-	pfURL, err := url.Parse(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/portforward", o.Config.Host, o.TargetNamespace, o.TargetPodName))
+// It calls o.Ready with the bound ports when the port forwarder is ready.
+func (pf *PortForwarder) Run(ctx context.Context, o Option) error {
+	listeners := make([]net.Listener, len(o.Ports))
+	closeListeners := func() {
+		for _, l := range listeners {
+			if l != nil {
+				_ = l.Close()
+			}
+		}
+	}
+	for i, p := range o.Ports {
+		listeners[i] = p.Listener
+	}
+	defer closeListeners()
+	if len(o.Ports) == 0 {
+		return fmt.Errorf("no port is given")
+	}
+	dialer, err := newDialer(o.Config, o.TargetNamespace, o.TargetPodName)
 	if err != nil {
-		return fmt.Errorf("could not build URL for portforward: %w", err)
+		return err
 	}
-	rt, upgrader, err := spdy.RoundTripperFor(o.Config)
+	streamConn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
 	if err != nil {
-		return fmt.Errorf("could not create a round tripper: %w", err)
+		return newDialError(err)
+	}
+	current := &currentConnection{conn: streamConn}
+	defer current.close()
+
+	var forwarded []portforward.ForwardedPort
+	for i, p := range o.Ports {
+		if listeners[i] == nil {
+			addr := net.JoinHostPort(p.SourceHost, strconv.Itoa(p.SourcePort))
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				return newListenError(addr, err)
+			}
+			listeners[i] = l
+		}
+		forwarded = append(forwarded, portforward.ForwardedPort{
+			Local:  uint16(portOf(listeners[i].Addr())),
+			Remote: uint16(p.TargetContainerPort),
+		})
+	}
+	for i, p := range o.Ports {
+		o.logger().Infof("Forwarding from %s -> %d", listeners[i].Addr(), p.TargetContainerPort)
+		if p.Observer != nil {
+			p.Observer.Ready(listeners[i].Addr())
+		}
+	}
+	if o.Ready != nil {
+		o.Ready(forwarded)
 	}
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: rt}, http.MethodPost, pfURL)
-	portPair := fmt.Sprintf("%d:%d", o.SourcePort, o.TargetContainerPort)
-	forwarder, err := portforward.NewOnAddresses(dialer, []string{o.SourceHost}, []string{portPair}, stopChan, readyChan, os.Stdout, os.Stderr)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	var acceptWG sync.WaitGroup
+	var requestID int32
+	for i := range o.Ports {
+		l, p := listeners[i], o.Ports[i]
+		acceptWG.Add(1)
+		go func() {
+			defer acceptWG.Done()
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				wg.Add(1)
+				id := int(atomic.AddInt32(&requestID, 1) - 1)
+				go func() {
+					defer wg.Done()
+					conn := observeConn(conn, p.Observer)
+					defer conn.Close()
+					handleConnection(conn, current.get(), id, p, portOf(l.Addr()), o.logger())
+				}()
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// stop accepting and abort the connections in progress
+			closeListeners()
+			// wg.Add is no longer called after the accept loops have returned
+			acceptWG.Wait()
+			current.close()
+			return nil
+		case <-o.Drain:
+			closeListeners()
+			acceptWG.Wait()
+			connectionsDone := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(connectionsDone)
+			}()
+			select {
+			case <-connectionsDone:
+			case <-ctx.Done():
+			}
+			current.close()
+			return nil
+		case <-current.get().CloseChan():
+			o.logger().Errorf("lost connection to pod, reconnecting")
+		case <-o.Reconnect:
+			o.logger().Errorf("reconnecting to pod")
+			// the active connections are aborted, and the clients need to connect again
+			current.close()
+		}
+		for _, p := range o.Ports {
+			if p.Observer != nil {
+				p.Observer.Reconnecting()
+			}
+		}
+		streamConn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+		if err != nil {
+			closeListeners()
+			acceptWG.Wait()
+			return newDialError(fmt.Errorf("could not reconnect to the pod: %w", err))
+		}
+		current.set(streamConn)
+		for i, p := range o.Ports {
+			if p.Observer != nil {
+				p.Observer.Ready(listeners[i].Addr())
+			}
+		}
+	}
+}
+
+// currentConnection holds the connection to the pod, which is replaced on reconnect.
+type currentConnection struct {
+	mu   sync.Mutex
+	conn httpstream.Connection
+}
+
+func (c *currentConnection) get() httpstream.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *currentConnection) set(conn httpstream.Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = conn
+}
+
+func (c *currentConnection) close() {
+	_ = c.get().Close()
+}
+
+// handleConnection forwards the local connection to a stream of the pod.
+func handleConnection(conn net.Conn, streamConn httpstream.Connection, requestID int, p PortPair, localPort int, logger Logger) {
+	logger.Infof("Handling connection for %d", localPort)
+	s, err := openPodStream(streamConn, requestID, p.TargetContainerPort)
 	if err != nil {
-		return fmt.Errorf("could not create a port forwarder: %w", err)
+		logger.Errorf("error forwarding port %d to pod: %s", p.TargetContainerPort, err)
+		if p.Observer != nil {
+			p.Observer.ConnectionError(err)
+		}
+		return
 	}
-	if err := forwarder.ForwardPorts(); err != nil {
-		return fmt.Errorf("could not run the port forwarder at %s: %w", portPair, err)
+	defer s.Close()
+
+	remoteDone := make(chan struct{})
+	go func() {
+		defer close(remoteDone)
+		_, _ = io.Copy(conn, s)
+	}()
+	go func() {
+		// inform the pod that no more data will be sent
+		defer s.CloseWrite()
+		_, _ = io.Copy(s, conn)
+	}()
+	<-remoteDone
+	if err := <-s.errorChan; err != nil {
+		logger.Errorf("error forwarding port %d to pod: %s", p.TargetContainerPort, err)
+		if p.Observer != nil {
+			p.Observer.ConnectionError(err)
+		}
+	}
+}
+
+// portOf returns the port of the address, or zero if it has no port.
+func portOf(addr net.Addr) int {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func newDialer(config *rest.Config, namespace, podName string) (httpstream.Dialer, error) {
+	pfURL, err := portForwardURL(config, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+	rt, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a round tripper: %w", err)
+	}
+	return spdy.NewDialer(upgrader, &http.Client{Transport: rt}, http.MethodPost, pfURL), nil
+}
+
+// portForwardURL returns the URL of portforward subresource of the pod.
+// It respects the path prefix of the host and the API path of the config.
+func portForwardURL(config *rest.Config, namespace, podName string) (*url.URL, error) {
+	client, err := corev1client.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a client: %w", err)
 	}
-	return nil
+	return client.RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward").
+		URL(), nil
 }
@@ -0,0 +1,389 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/envoy"
+	"github.com/int128/kubectl-external-forward/pkg/fakeapiserver"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// createPod creates a pod which forwards the container ports 10000 and 10001 to the upstream.
+func createPod(t *testing.T, s *fakeapiserver.Server, upstream *net.TCPAddr) *corev1.Pod {
+	t.Helper()
+	config, err := envoy.NewConfig([]tunnel.Tunnel{
+		{RemoteHost: upstream.IP.String(), RemotePort: upstream.Port, ContainerPort: 10000},
+		{RemoteHost: upstream.IP.String(), RemotePort: upstream.Port, ContainerPort: 10001},
+	}, envoy.Option{})
+	if err != nil {
+		t.Fatalf("envoy.NewConfig error: %s", err)
+	}
+	c, err := kubernetes.NewForConfig(s.Config())
+	if err != nil {
+		t.Fatalf("NewForConfig error: %s", err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "envoy-"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "envoy", Args: []string{"--config-yaml", config}}},
+		},
+	}
+	pod, err = c.CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Create error: %s", err)
+	}
+	return pod
+}
+
+// recordLogger records the messages.
+type recordLogger struct {
+	messages chan string
+}
+
+func (l recordLogger) Infof(format string, args ...interface{}) {
+	l.messages <- fmt.Sprintf(format, args...)
+}
+
+func (l recordLogger) Errorf(format string, args ...interface{}) {
+	l.messages <- fmt.Sprintf(format, args...)
+}
+
+func TestPortForwarder_Run(t *testing.T) {
+	s := fakeapiserver.New()
+	defer s.Close()
+	pod := createPod(t, s, fakeapiserver.StartEchoServer(t))
+	var pf PortForwarder
+
+	t.Run("MultiplePorts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		logger := recordLogger{messages: make(chan string, 10)}
+		ready := make(chan []portforward.ForwardedPort, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- pf.Run(ctx, Option{
+				Config:          s.Config(),
+				TargetNamespace: pod.Namespace,
+				TargetPodName:   pod.Name,
+				Ports: []PortPair{
+					{SourceHost: "127.0.0.1", TargetContainerPort: 10000},
+					{SourceHost: "127.0.0.1", TargetContainerPort: 10001},
+				},
+				Ready:  func(ports []portforward.ForwardedPort) { ready <- ports },
+				Logger: logger,
+			})
+		}()
+		var ports []portforward.ForwardedPort
+		select {
+		case ports = <-ready:
+		case err := <-done:
+			t.Fatalf("Run returned before ready: %v", err)
+		}
+		if len(ports) != 2 {
+			t.Fatalf("ports want 2 but got %+v", ports)
+		}
+		for i, port := range ports {
+			if port.Local == 0 {
+				t.Errorf("local port %d wants allocated but got 0", i)
+			}
+			if want := uint16(10000 + i); port.Remote != want {
+				t.Errorf("remote port %d wants %d but got %d", i, want, port.Remote)
+			}
+			got, err := fakeapiserver.Echo(fmt.Sprintf("127.0.0.1:%d", port.Local), "hello")
+			if err != nil {
+				t.Fatalf("echo error: %s", err)
+			}
+			if diff := cmp.Diff("hello", got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		}
+		if want := fmt.Sprintf("Forwarding from 127.0.0.1:%d -> 10000", ports[0].Local); <-logger.messages != want {
+			t.Errorf("first message wants %s", want)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run error: %s", err)
+		}
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		ready := make(chan []portforward.ForwardedPort, 1)
+		reconnect := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- pf.Run(ctx, Option{
+				Config:          s.Config(),
+				TargetNamespace: pod.Namespace,
+				TargetPodName:   pod.Name,
+				Ports:           []PortPair{{SourceHost: "127.0.0.1", TargetContainerPort: 10000}},
+				Reconnect:       reconnect,
+				Ready:           func(ports []portforward.ForwardedPort) { ready <- ports },
+				Logger:          recordLogger{messages: make(chan string, 10)},
+			})
+		}()
+		addr := fmt.Sprintf("127.0.0.1:%d", (<-ready)[0].Local)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("could not connect: %s", err)
+		}
+		defer conn.Close()
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("SetDeadline error: %s", err)
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("Write error: %s", err)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+			t.Fatalf("ReadFull error: %s", err)
+		}
+
+		reconnect <- struct{}{}
+		// the connection in progress is aborted
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Errorf("connection wants closed but got %s", err)
+		}
+		// a new connection is forwarded over the new connection to the pod
+		var got string
+		for i := 0; i < 10; i++ {
+			if got, err = fakeapiserver.Echo(addr, "again"); err == nil && got == "again" {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if diff := cmp.Diff("again", got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run error: %s", err)
+		}
+	})
+
+	t.Run("ConcurrentConnections", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		ready := make(chan []portforward.ForwardedPort, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- pf.Run(ctx, Option{
+				Config:          s.Config(),
+				TargetNamespace: pod.Namespace,
+				TargetPodName:   pod.Name,
+				Ports: []PortPair{
+					{SourceHost: "127.0.0.1", TargetContainerPort: 10000},
+					{SourceHost: "127.0.0.1", TargetContainerPort: 10001},
+				},
+				Ready:  func(ports []portforward.ForwardedPort) { ready <- ports },
+				Logger: recordLogger{messages: make(chan string, 100)},
+			})
+		}()
+		ports := <-ready
+		// each connection has its own pair of the data and error streams
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				want := fmt.Sprintf("hello-%d", i)
+				got, err := fakeapiserver.Echo(fmt.Sprintf("127.0.0.1:%d", ports[i%2].Local), want)
+				if err != nil {
+					t.Errorf("echo error: %s", err)
+					return
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("mismatch (-want +got):\n%s", diff)
+				}
+			}()
+		}
+		wg.Wait()
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run error: %s", err)
+		}
+	})
+
+	t.Run("ErrorStream", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		var observer recordObserver
+		ready := make(chan []portforward.ForwardedPort, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- pf.Run(ctx, Option{
+				Config:          s.Config(),
+				TargetNamespace: pod.Namespace,
+				TargetPodName:   pod.Name,
+				// the pod does not listen on the port
+				Ports:  []PortPair{{SourceHost: "127.0.0.1", TargetContainerPort: 10002, Observer: &observer}},
+				Ready:  func(ports []portforward.ForwardedPort) { ready <- ports },
+				Logger: recordLogger{messages: make(chan string, 10)},
+			})
+		}()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", (<-ready)[0].Local))
+		if err != nil {
+			t.Fatalf("could not connect: %s", err)
+		}
+		defer conn.Close()
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("SetDeadline error: %s", err)
+		}
+		// the connection is closed after the error stream is read
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Fatalf("ReadAll error: %s", err)
+		}
+		_, errs := observer.snapshot()
+		if len(errs) != 1 {
+			t.Fatalf("errors want 1 but got %v", errs)
+		}
+		want := "error from the pod: an error occurred forwarding 10002 -> 10002: failed to connect to localhost:10002: connection refused"
+		if diff := cmp.Diff(want, errs[0].Error()); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run error: %s", err)
+		}
+	})
+
+	t.Run("ConnectionLost", func(t *testing.T) {
+		pod := createPod(t, s, fakeapiserver.StartEchoServer(t))
+		var observer recordObserver
+		ready := make(chan []portforward.ForwardedPort, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- pf.Run(context.TODO(), Option{
+				Config:          s.Config(),
+				TargetNamespace: pod.Namespace,
+				TargetPodName:   pod.Name,
+				Ports:           []PortPair{{SourceHost: "127.0.0.1", TargetContainerPort: 10000, Observer: &observer}},
+				Ready:           func(ports []portforward.ForwardedPort) { ready <- ports },
+				Logger:          recordLogger{messages: make(chan string, 10)},
+			})
+		}()
+		<-ready
+		c, err := kubernetes.NewForConfig(s.Config())
+		if err != nil {
+			t.Fatalf("NewForConfig error: %s", err)
+		}
+		// the connection to the pod is closed, and the reconnect fails
+		if err := c.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		select {
+		case err := <-done:
+			if KindOf(err) != PodGoneError {
+				t.Errorf("error wants %s but got %v", PodGoneError, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Run wants returned after the pod is deleted")
+		}
+		events, _ := observer.snapshot()
+		if diff := cmp.Diff([]string{"Ready", "Reconnecting"}, events); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("PodGone", func(t *testing.T) {
+		err := pf.Run(context.TODO(), Option{
+			Config:          s.Config(),
+			TargetNamespace: pod.Namespace,
+			TargetPodName:   "no-such-pod",
+			Ports:           []PortPair{{SourceHost: "127.0.0.1", TargetContainerPort: 10000}},
+			Ready:           func([]portforward.ForwardedPort) { t.Errorf("Ready wants not called") },
+			Logger:          recordLogger{messages: make(chan string, 10)},
+		})
+		if KindOf(err) != PodGoneError {
+			t.Errorf("error wants %s but got %v", PodGoneError, err)
+		}
+	})
+
+	t.Run("PortInUse", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %s", err)
+		}
+		defer l.Close()
+		err = pf.Run(context.TODO(), Option{
+			Config:          s.Config(),
+			TargetNamespace: pod.Namespace,
+			TargetPodName:   pod.Name,
+			Ports: []PortPair{
+				{SourceHost: "127.0.0.1", TargetContainerPort: 10000},
+				{SourceHost: "127.0.0.1", SourcePort: l.Addr().(*net.TCPAddr).Port, TargetContainerPort: 10001},
+			},
+			Ready:  func([]portforward.ForwardedPort) { t.Errorf("Ready wants not called") },
+			Logger: recordLogger{messages: make(chan string, 10)},
+		})
+		if KindOf(err) != PortInUseError {
+			t.Errorf("error wants %s but got %v", PortInUseError, err)
+		}
+	})
+}
+
+func Test_classifyDialError(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	testCases := map[string]struct {
+		err  error
+		want ErrorKind
+	}{
+		"Unauthorized":       {apierrors.NewUnauthorized("invalid token"), AuthError},
+		"Forbidden":          {apierrors.NewForbidden(pods, "envoy", errors.New("denied")), AuthError},
+		"NotFound":           {apierrors.NewNotFound(pods, "envoy"), PodGoneError},
+		"PodNotRunning":      {errors.New(`error upgrading connection: pod envoy is not running`), PodGoneError},
+		"ServiceUnavailable": {apierrors.NewServiceUnavailable("overloaded"), NetworkError},
+		"ConnectionRefused":  {fmt.Errorf("dial tcp: %w", syscall.ECONNREFUSED), NetworkError},
+		"EOF":                {fmt.Errorf("read: %w", io.EOF), NetworkError},
+		"Unknown":            {errors.New("something wrong"), UnknownError},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := classifyDialError(testCase.err); got != testCase.want {
+				t.Errorf("kind wants %s but got %s", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	err := fmt.Errorf("tunnel: %w", &Error{Kind: PortInUseError, Err: errors.New("address already in use")})
+	if got := KindOf(err); got != PortInUseError {
+		t.Errorf("kind wants %s but got %s", PortInUseError, got)
+	}
+	if got := KindOf(errors.New("plain")); got != UnknownError {
+		t.Errorf("kind wants %s but got %s", UnknownError, got)
+	}
+}
+
+func TestPortForwardURL(t *testing.T) {
+	got, err := portForwardURL(&rest.Config{Host: "https://example.com:6443/k8s/clusters/c-1"}, "default", "envoy")
+	if err != nil {
+		t.Fatalf("portForwardURL error: %s", err)
+	}
+	want := "https://example.com:6443/k8s/clusters/c-1/api/v1/namespaces/default/pods/envoy/portforward"
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,159 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// Dial opens a connection to the port of the pod.
+// Unlike Run, it does not listen on a local port.
+// Caller must close the connection.
+func (pf *PortForwarder) Dial(ctx context.Context, o StreamOption) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dialer, err := newDialer(o.Config, o.TargetNamespace, o.TargetPodName)
+	if err != nil {
+		return nil, err
+	}
+	streamConn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, newDialError(err)
+	}
+	s, err := openPodStream(streamConn, 0, o.TargetContainerPort)
+	if err != nil {
+		streamConn.Close()
+		return nil, err
+	}
+	s.ownConn = true
+	return s, nil
+}
+
+// Stream connects the reader and writer to the port of the pod.
+// It dials the SPDY streams of the pod directly and forwards a single connection.
+//
+// It returns nil when the remote side has closed the stream.
+// It returns an error if it could not connect to the pod.
+func (pf *PortForwarder) Stream(ctx context.Context, o StreamOption, in io.Reader, out io.Writer) error {
+	conn, err := pf.Dial(ctx, o)
+	if err != nil {
+		return err
+	}
+	s := conn.(*podStream)
+	defer s.Close()
+
+	remoteDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, s)
+		remoteDone <- err
+	}()
+	go func() {
+		// inform the pod that no more data will be sent
+		defer s.CloseWrite()
+		_, _ = io.Copy(s, in)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-remoteDone:
+		if err != nil {
+			return fmt.Errorf("could not copy from the pod: %w", err)
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-s.errorChan:
+		return err
+	}
+}
+
+// podStream is a pair of the data and error streams to a port of the pod.
+// It implements net.Conn but the deadlines are not supported.
+type podStream struct {
+	streamConn  httpstream.Connection
+	dataStream  httpstream.Stream
+	errorStream httpstream.Stream
+	errorChan   chan error
+	port        int
+	// if true, close the underlying connection on Close
+	ownConn bool
+}
+
+func openPodStream(streamConn httpstream.Connection, requestID, port int) (*podStream, error) {
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(port))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
+	errorStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("could not create an error stream: %w", err)
+	}
+	// we are not writing to this stream
+	if err := errorStream.Close(); err != nil {
+		return nil, fmt.Errorf("could not close the error stream: %w", err)
+	}
+	errorChan := make(chan error, 1)
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errorChan <- fmt.Errorf("could not read from the error stream: %w", err)
+		case len(message) > 0:
+			errorChan <- fmt.Errorf("error from the pod: %s", message)
+		}
+		close(errorChan)
+	}()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		streamConn.RemoveStreams(errorStream)
+		return nil, fmt.Errorf("could not create a data stream: %w", err)
+	}
+	return &podStream{
+		streamConn:  streamConn,
+		dataStream:  dataStream,
+		errorStream: errorStream,
+		errorChan:   errorChan,
+		port:        port,
+	}, nil
+}
+
+func (s *podStream) Read(b []byte) (int, error)  { return s.dataStream.Read(b) }
+func (s *podStream) Write(b []byte) (int, error) { return s.dataStream.Write(b) }
+
+// CloseWrite informs the pod that no more data will be sent.
+func (s *podStream) CloseWrite() error { return s.dataStream.Close() }
+
+func (s *podStream) Close() error {
+	_ = s.dataStream.Reset()
+	s.streamConn.RemoveStreams(s.dataStream, s.errorStream)
+	if s.ownConn {
+		return s.streamConn.Close()
+	}
+	return nil
+}
+
+func (s *podStream) LocalAddr() net.Addr                { return podAddr{} }
+func (s *podStream) RemoteAddr() net.Addr               { return podAddr{port: s.port} }
+func (s *podStream) SetDeadline(t time.Time) error      { return nil }
+func (s *podStream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *podStream) SetWriteDeadline(t time.Time) error { return nil }
+
+type podAddr struct {
+	port int
+}
+
+func (a podAddr) Network() string { return "portforward" }
+func (a podAddr) String() string  { return fmt.Sprintf("pod:%d", a.port) }
@@ -0,0 +1,286 @@
+// Package session provides a library to open the tunnels from a Go program, such as integration tests.
+//
+//	s, err := session.Start(ctx, session.Config{RESTConfig: config}, []session.Tunnel{
+//		{Name: "db", Tunnel: tunnel.Tunnel{RemoteHost: "db.staging", RemotePort: 5432}},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	db, err := sql.Open("postgres", "postgres://user@"+s.Addr("db")+"/app")
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	"k8s.io/client-go/rest"
+)
+
+// eventsBufferSize is the capacity of the channel returned by Session.Events.
+const eventsBufferSize = 100
+
+// Config represents the cluster and pod of a session.
+type Config struct {
+	// RESTConfig is the config of the cluster. Required.
+	RESTConfig *rest.Config
+	// Namespace of the pod. Default to default.
+	Namespace string
+	// PodImage is the image of Envoy. Default to externalforwarder.DefaultPodImage.
+	PodImage string
+	// Refuse the tunnels which are not allowed by the policy in the ConfigMap of NAMESPACE/NAME.
+	// Default to externalforwarder.DefaultPolicyConfigMap, same as the command.
+	PolicyConfigMap string
+	// If true, do not read the policy
+	DisablePolicy bool
+	// Behavior when a port-forwarder fails. Default to abort
+	OnTunnelFailure externalforwarder.TunnelFailurePolicy
+}
+
+// Tunnel represents a tunnel with the name.
+// If LocalHost is empty, 127.0.0.1 is used.
+// If LocalPort is zero, an available port is allocated.
+type Tunnel struct {
+	// Name is the key of Session.Addr
+	Name string
+	tunnel.Tunnel
+}
+
+// Session represents the running tunnels.
+// It is safe for concurrent use.
+type Session struct {
+	// names maps the container port to the name of a tunnel
+	names  map[int]string
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	mu     sync.Mutex
+	addrs  map[string]string
+	events chan event.Event
+	closed bool
+	// closed when all tunnels are ready
+	allReady chan struct{}
+	// closed when a tunnel has failed before all tunnels are ready
+	failed  chan struct{}
+	failure error
+}
+
+// Start creates a pod and starts the port-forwarders of the tunnels.
+// It returns when all tunnels are ready, or returns an error if they could not be ready.
+// If a tunnel fails before all tunnels are ready, it returns the error regardless of OnTunnelFailure.
+// If ctx is canceled before all tunnels are ready, it deletes the pod and returns the error of ctx.
+//
+// The session is stopped when Close is called or ctx is canceled.
+// Caller must call Close to delete the pod.
+func Start(ctx context.Context, cfg Config, tunnels []Tunnel) (*Session, error) {
+	if cfg.RESTConfig == nil {
+		return nil, fmt.Errorf("RESTConfig is required")
+	}
+	s, ts, err := newSession(tunnels)
+	if err != nil {
+		return nil, err
+	}
+	o := externalforwarder.Option{
+		Config:              cfg.RESTConfig,
+		Namespace:           cfg.Namespace,
+		Tunnels:             ts,
+		PodImage:            cfg.PodImage,
+		PolicyConfigMap:     cfg.PolicyConfigMap,
+		OnTunnelFailure:     cfg.OnTunnelFailure,
+		Events:              emitter{s},
+		Monitor:             monitor{s},
+		PortForwarderOut:    io.Discard,
+		PortForwarderErrOut: io.Discard,
+		IgnoreInterrupt:     true,
+	}
+	if o.Namespace == "" {
+		o.Namespace = "default"
+	}
+	if o.PodImage == "" {
+		o.PodImage = externalforwarder.DefaultPodImage
+	}
+	if o.PolicyConfigMap == "" {
+		o.PolicyConfigMap = externalforwarder.DefaultPolicyConfigMap
+	}
+	if cfg.DisablePolicy {
+		o.PolicyConfigMap = ""
+	}
+	if o.OnTunnelFailure == "" {
+		o.OnTunnelFailure = externalforwarder.AbortOnTunnelFailure
+	}
+
+	f := externalforwarder.ExternalForwarder{PortForwarder: &portforwarder.PortForwarder{}}
+	sessionCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	go func() {
+		defer close(s.done)
+		s.err = f.Do(sessionCtx, o)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.events)
+	}()
+
+	select {
+	case <-s.allReady:
+		return s, nil
+	case <-s.failed:
+		_ = s.Close()
+		return nil, s.failure
+	case <-ctx.Done():
+		_ = s.Close()
+		return nil, ctx.Err()
+	case <-s.done:
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, fmt.Errorf("stopped before the tunnels are ready: %w", context.Canceled)
+	}
+}
+
+// newSession returns a session of the tunnels and the tunnels to open.
+// The local port of a tunnel is allocated when the port-forwarder listens,
+// and the address is taken from the observer of the tunnel.
+func newSession(tunnels []Tunnel) (*Session, []tunnel.Tunnel, error) {
+	if len(tunnels) == 0 {
+		return nil, nil, fmt.Errorf("no tunnel is given")
+	}
+	seen := make(map[string]bool)
+	var ts []tunnel.Tunnel
+	for _, t := range tunnels {
+		if seen[t.Name] {
+			return nil, nil, fmt.Errorf("duplicate tunnel name %q", t.Name)
+		}
+		seen[t.Name] = true
+		if t.LocalHost == "" {
+			t.LocalHost = "127.0.0.1"
+		}
+		ts = append(ts, t.Tunnel)
+	}
+	// a container port identifies a tunnel even if the local port is zero
+	ts = tunnel.AllocateContainerPorts(ts)
+	s := &Session{
+		names:    make(map[int]string),
+		done:     make(chan struct{}),
+		addrs:    make(map[string]string),
+		events:   make(chan event.Event, eventsBufferSize),
+		allReady: make(chan struct{}),
+		failed:   make(chan struct{}),
+	}
+	for i, t := range ts {
+		s.names[t.PodPort()] = tunnels[i].Name
+	}
+	return s, ts, nil
+}
+
+// Addr returns the local address of the tunnel, such as 127.0.0.1:5432.
+// It returns an empty string if no tunnel has the name.
+func (s *Session) Addr(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addrs[name]
+}
+
+// Events returns a channel of the events of the pod and tunnels.
+// An event is dropped if the channel is full.
+// The channel is closed when the session is stopped.
+func (s *Session) Events() <-chan event.Event {
+	return s.events
+}
+
+// Done returns a channel which is closed when the session is stopped.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops the tunnels and deletes the pod.
+// It returns an error if the session was stopped by an error.
+func (s *Session) Close() error {
+	s.cancel()
+	<-s.done
+	if s.err != nil && !errors.Is(s.err, context.Canceled) {
+		return s.err
+	}
+	return nil
+}
+
+// emitter receives the events of the session.
+type emitter struct {
+	s *Session
+}
+
+func (e emitter) Emit(ev event.Event) {
+	s := e.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- ev:
+	default:
+	}
+	// an error of a tunnel has the local address
+	if ev.Type != event.Error || ev.LocalAddr == "" || s.failure != nil || len(s.addrs) >= len(s.names) {
+		return
+	}
+	s.failure = fmt.Errorf("tunnel %s: %s", ev.LocalAddr, ev.Error)
+	close(s.failed)
+}
+
+// monitor receives the local addresses of the tunnels.
+type monitor struct {
+	s *Session
+}
+
+func (m monitor) Observer(t tunnel.Tunnel) portforwarder.Observer {
+	name, ok := m.s.names[t.PodPort()]
+	if !ok {
+		return nil
+	}
+	return readyObserver{s: m.s, name: name}
+}
+
+func (m monitor) Reconnect(tunnel.Tunnel) <-chan struct{} {
+	return nil
+}
+
+// readyObserver records the local address of a tunnel.
+type readyObserver struct {
+	s    *Session
+	name string
+}
+
+func (o readyObserver) Ready(addr net.Addr) {
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.addrs[o.name]; ok {
+		return
+	}
+	s.addrs[o.name] = addr.String()
+	if len(s.addrs) == len(s.names) {
+		close(s.allReady)
+	}
+}
+
+func (o readyObserver) ConnectionOpened(net.Addr) {}
+
+func (o readyObserver) ConnectionClosed(net.Addr) {}
+
+func (o readyObserver) BytesSent(int) {}
+
+func (o readyObserver) BytesReceived(int) {}
+
+func (o readyObserver) ConnectionError(error) {}
+
+func (o readyObserver) Reconnecting() {}
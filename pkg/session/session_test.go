@@ -0,0 +1,162 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/externalforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/fakeapiserver"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestStart(t *testing.T) {
+	upstream := fakeapiserver.StartEchoServer(t)
+	tunnels := []Tunnel{
+		{Name: "echo", Tunnel: tunnel.Tunnel{RemoteHost: upstream.IP.String(), RemotePort: upstream.Port}},
+		{Name: "echo2", Tunnel: tunnel.Tunnel{RemoteHost: upstream.IP.String(), RemotePort: upstream.Port}},
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		ctx := context.TODO()
+		s := fakeapiserver.New()
+		defer s.Close()
+		session, err := Start(ctx, Config{RESTConfig: s.Config()}, tunnels)
+		if err != nil {
+			t.Fatalf("Start error: %s", err)
+		}
+		for _, name := range []string{"echo", "echo2"} {
+			got, err := fakeapiserver.Echo(session.Addr(name), "hello")
+			if err != nil {
+				t.Fatalf("could not connect to %s: %s", name, err)
+			}
+			if got != "hello" {
+				t.Errorf("response wants hello but got %s", got)
+			}
+		}
+		if len(s.Pods()) != 1 {
+			t.Errorf("pods want 1 but got %d", len(s.Pods()))
+		}
+
+		if err := session.Close(); err != nil {
+			t.Errorf("Close error: %s", err)
+		}
+		if len(s.Pods()) != 0 {
+			t.Errorf("pods want none after Close but got %d", len(s.Pods()))
+		}
+		var types []event.Type
+		for e := range session.Events() {
+			types = append(types, e.Type)
+		}
+		for _, want := range []event.Type{event.PodCreated, event.PodRunning, event.TunnelReady, event.PodDeleted} {
+			if !containsType(types, want) {
+				t.Errorf("events want %s but got %v", want, types)
+			}
+		}
+	})
+
+	t.Run("PodFailed", func(t *testing.T) {
+		ctx := context.TODO()
+		s := fakeapiserver.New()
+		defer s.Close()
+		s.PodPhase = corev1.PodFailed
+		_, err := Start(ctx, Config{RESTConfig: s.Config()}, tunnels)
+		if err == nil || !strings.Contains(err.Error(), "has Failed") {
+			t.Errorf("error wants pod failure but got %v", err)
+		}
+		if len(s.Pods()) != 0 {
+			t.Errorf("pods want none but got %d", len(s.Pods()))
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		s := fakeapiserver.New()
+		defer s.Close()
+		s.PodPhase = corev1.PodPending
+		ctx, cancel := context.WithTimeout(context.TODO(), 500*time.Millisecond)
+		defer cancel()
+		_, err := Start(ctx, Config{RESTConfig: s.Config(), OnTunnelFailure: externalforwarder.RetryOnTunnelFailure}, tunnels)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error wants %s but got %v", context.DeadlineExceeded, err)
+		}
+		if len(s.Pods()) != 0 {
+			t.Errorf("pods want none but got %d", len(s.Pods()))
+		}
+	})
+
+	t.Run("DuplicateName", func(t *testing.T) {
+		_, err := Start(context.TODO(), Config{RESTConfig: &rest.Config{}}, []Tunnel{{Name: "db"}, {Name: "db"}})
+		if err == nil || !strings.Contains(err.Error(), "duplicate") {
+			t.Errorf("error wants duplicate but got %v", err)
+		}
+	})
+}
+
+func TestEmitter_Emit(t *testing.T) {
+	newTestSession := func(t *testing.T) *Session {
+		s, _, err := newSession([]Tunnel{{Name: "db"}, {Name: "cache"}})
+		if err != nil {
+			t.Fatalf("newSession error: %s", err)
+		}
+		return s
+	}
+	tunnelError := event.Event{Type: event.Error, LocalAddr: "127.0.0.1:5432", Error: "pod is gone"}
+
+	t.Run("TunnelFailed", func(t *testing.T) {
+		s := newTestSession(t)
+		emitter{s}.Emit(event.Event{Type: event.Error, Error: "connection refused"})
+		if isClosed(s.failed) {
+			t.Fatalf("failed wants open on an error of a connection")
+		}
+		emitter{s}.Emit(tunnelError)
+		emitter{s}.Emit(tunnelError)
+		if !isClosed(s.failed) {
+			t.Fatalf("failed wants closed")
+		}
+		if want := "tunnel 127.0.0.1:5432: pod is gone"; s.failure == nil || s.failure.Error() != want {
+			t.Errorf("failure wants %s but got %v", want, s.failure)
+		}
+	})
+
+	t.Run("AfterReady", func(t *testing.T) {
+		s := newTestSession(t)
+		monitor{s}.Observer(tunnel.Tunnel{ContainerPort: 10000}).Ready(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5432})
+		monitor{s}.Observer(tunnel.Tunnel{ContainerPort: 10001}).Ready(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6379})
+		if !isClosed(s.allReady) {
+			t.Fatalf("allReady wants closed")
+		}
+		if diff := cmp.Diff("127.0.0.1:6379", s.Addr("cache")); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+		// continue or retry policy keeps the session
+		emitter{s}.Emit(tunnelError)
+		if isClosed(s.failed) {
+			t.Errorf("failed wants open after ready")
+		}
+	})
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func containsType(types []event.Type, want event.Type) bool {
+	for _, typ := range types {
+		if typ == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+// Package tui provides an interactive dashboard of the tunnels.
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/portforwarder"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+// maxLogLines is the number of log lines kept in the dashboard.
+const maxLogLines = 1000
+
+// State represents the state of a tunnel.
+type State string
+
+const (
+	Pending      State = "pending"
+	PodStarting  State = "pod starting"
+	Forwarding   State = "forwarding"
+	Reconnecting State = "reconnecting"
+	Failed       State = "failed"
+)
+
+// Row represents a row of the tunnel table.
+type Row struct {
+	Local         string
+	Remote        string
+	State         State
+	Connections   int
+	BytesSent     int64
+	BytesReceived int64
+	Pod           string
+	Node          string
+}
+
+// Dashboard holds the state of the tunnels and logs.
+// It receives the events, observes the tunnels and collects the logs.
+// It is safe for concurrent use.
+type Dashboard struct {
+	defaultNamespace string
+
+	mu        sync.Mutex
+	tunnels   []tunnel.Tunnel
+	rows      []Row
+	reconnect []chan struct{}
+	logs      []string
+	partial   []byte
+}
+
+// New returns a dashboard of the tunnels.
+// The default namespace is used for a tunnel without namespace.
+func New(tunnels []tunnel.Tunnel, defaultNamespace string) *Dashboard {
+	d := &Dashboard{defaultNamespace: defaultNamespace, tunnels: tunnels}
+	for _, t := range tunnels {
+		d.rows = append(d.rows, Row{
+			Local:  localAddr(t),
+			Remote: fmt.Sprintf("%s:%d", t.RemoteHost, t.RemotePort),
+			State:  Pending,
+		})
+		d.reconnect = append(d.reconnect, make(chan struct{}, 1))
+	}
+	return d
+}
+
+func localAddr(t tunnel.Tunnel) string {
+	return net.JoinHostPort(t.LocalHost, strconv.Itoa(t.LocalPort))
+}
+
+// Rows returns a snapshot of the tunnel table.
+func (d *Dashboard) Rows() []Row {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Row{}, d.rows...)
+}
+
+// Logs returns the last n lines of the logs.
+func (d *Dashboard) Logs(n int) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n > len(d.logs) {
+		n = len(d.logs)
+	}
+	return append([]string{}, d.logs[len(d.logs)-n:]...)
+}
+
+// Write appends the lines to the log pane.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.logs = append(d.logs, strings.TrimRight(string(d.partial[:i]), "\r"))
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.logs) > maxLogLines {
+		d.logs = d.logs[len(d.logs)-maxLogLines:]
+	}
+	return len(p), nil
+}
+
+// RequestReconnect requests to reconnect the tunnel of the index.
+func (d *Dashboard) RequestReconnect(i int) {
+	if i < 0 || i >= len(d.reconnect) {
+		return
+	}
+	select {
+	case d.reconnect[i] <- struct{}{}:
+	default:
+	}
+}
+
+// Emit implements event.Emitter.
+// It updates the pod of the tunnels and the failures.
+func (d *Dashboard) Emit(e event.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch e.Type {
+	case event.PodCreated, event.PodRunning, event.PodDeleted:
+		for i, t := range d.tunnels {
+			if !d.inPod(t, e) {
+				continue
+			}
+			row := &d.rows[i]
+			switch e.Type {
+			case event.PodCreated:
+				row.Pod, row.Node, row.State = e.Pod, "", PodStarting
+			case event.PodRunning:
+				row.Node = e.Node
+			case event.PodDeleted:
+				if row.State != Failed {
+					row.State = Pending
+				}
+			}
+		}
+	case event.Error:
+		if i := d.indexOf(e.LocalAddr); i >= 0 {
+			d.rows[i].State = Failed
+		}
+	}
+}
+
+// inPod returns true if the tunnel belongs to the pod of the event.
+func (d *Dashboard) inPod(t tunnel.Tunnel, e event.Event) bool {
+	namespace := t.Namespace
+	if namespace == "" {
+		namespace = d.defaultNamespace
+	}
+	return t.Context == e.Context && strings.HasPrefix(e.Pod, namespace+"/")
+}
+
+// indexOf returns the index of the tunnel which listens on the address, or -1.
+func (d *Dashboard) indexOf(addr string) int {
+	for i, row := range d.rows {
+		if row.Local == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// Observer implements externalforwarder.TunnelMonitor.
+func (d *Dashboard) Observer(t tunnel.Tunnel) portforwarder.Observer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := d.indexOf(localAddr(t))
+	if i < 0 {
+		return nil
+	}
+	return tunnelObserver{d: d, i: i}
+}
+
+// Reconnect implements externalforwarder.TunnelMonitor.
+func (d *Dashboard) Reconnect(t tunnel.Tunnel) <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := d.indexOf(localAddr(t))
+	if i < 0 {
+		return nil
+	}
+	return d.reconnect[i]
+}
+
+// tunnelObserver updates the row of a tunnel.
+type tunnelObserver struct {
+	d *Dashboard
+	i int
+}
+
+func (o tunnelObserver) update(f func(row *Row)) {
+	o.d.mu.Lock()
+	defer o.d.mu.Unlock()
+	f(&o.d.rows[o.i])
+}
+
+func (o tunnelObserver) Ready(net.Addr) {
+	o.update(func(row *Row) { row.State = Forwarding })
+}
+
+func (o tunnelObserver) ConnectionOpened(net.Addr) {
+	o.update(func(row *Row) { row.Connections++ })
+}
+
+func (o tunnelObserver) ConnectionClosed(net.Addr) {
+	o.update(func(row *Row) { row.Connections-- })
+}
+
+func (o tunnelObserver) BytesSent(n int) {
+	o.update(func(row *Row) { row.BytesSent += int64(n) })
+}
+
+func (o tunnelObserver) BytesReceived(n int) {
+	o.update(func(row *Row) { row.BytesReceived += int64(n) })
+}
+
+func (o tunnelObserver) ConnectionError(error) {}
+
+func (o tunnelObserver) Reconnecting() {
+	o.update(func(row *Row) { row.State = Reconnecting })
+}
@@ -0,0 +1,97 @@
+package tui
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/int128/kubectl-external-forward/pkg/event"
+	"github.com/int128/kubectl-external-forward/pkg/tunnel"
+)
+
+func TestDashboard(t *testing.T) {
+	db := tunnel.Tunnel{LocalHost: "127.0.0.1", LocalPort: 15432, RemoteHost: "db", RemotePort: 5432}
+	api := tunnel.Tunnel{LocalHost: "127.0.0.1", LocalPort: 10443, RemoteHost: "api", RemotePort: 443, Context: "prod"}
+	d := New([]tunnel.Tunnel{db, api}, "default")
+
+	event.Emit(d, event.Event{Type: event.PodCreated, Pod: "default/kubectl-external-forward-abcde"})
+	event.Emit(d, event.Event{Type: event.PodRunning, Pod: "default/kubectl-external-forward-abcde", Node: "node-1"})
+	o := d.Observer(db)
+	o.Ready(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 15432})
+	o.ConnectionOpened(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50001})
+	o.BytesSent(100)
+	o.BytesReceived(2048)
+	event.Emit(d, event.Event{Type: event.Error, LocalAddr: "127.0.0.1:10443", Error: "address already in use"})
+
+	want := []Row{
+		{
+			Local:         "127.0.0.1:15432",
+			Remote:        "db:5432",
+			State:         Forwarding,
+			Connections:   1,
+			BytesSent:     100,
+			BytesReceived: 2048,
+			Pod:           "default/kubectl-external-forward-abcde",
+			Node:          "node-1",
+		},
+		{
+			Local:  "127.0.0.1:10443",
+			Remote: "api:443",
+			State:  Failed,
+		},
+	}
+	if diff := cmp.Diff(want, d.Rows()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("Reconnect", func(t *testing.T) {
+		ch := d.Reconnect(db)
+		d.RequestReconnect(0)
+		d.RequestReconnect(0)
+		<-ch
+		select {
+		case <-ch:
+			t.Errorf("requests should be merged")
+		default:
+		}
+	})
+
+	t.Run("Logs", func(t *testing.T) {
+		_, _ = fmt.Fprint(d, "line1\nline2\r\nline")
+		_, _ = fmt.Fprint(d, "3\n")
+		if diff := cmp.Diff([]string{"line2", "line3"}, d.Logs(2)); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("\x1b[A\x1b[Bjkrq\x03x"))
+	want := []key{keyUp, keyDown, keyDown, keyUp, keyReconnect, keyQuit, keyQuit}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	keys := make(chan key)
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		readKeys(strings.NewReader("jq"), keys, done)
+	}()
+	if k := <-keys; k != keyDown {
+		t.Errorf("key wants %v but got %v", keyDown, k)
+	}
+	// it should return without anyone receiving the rest of the keys
+	close(done)
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("readKeys did not return")
+	}
+}
@@ -0,0 +1,74 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+const header = "kubectl external-forward    ↑/↓ select    r reconnect    q quit"
+
+// Render returns the lines of the screen in the size.
+// The selected row is marked in the tunnel table,
+// and the remaining lines are filled with the last logs.
+func Render(rows []Row, logs []string, selected, width, height int) []string {
+	lines := []string{header, ""}
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  LOCAL\tREMOTE\tSTATE\tCONNS\tOUT\tIN\tPOD\tNODE")
+	for i, row := range rows {
+		marker := " "
+		if i == selected {
+			marker = ">"
+		}
+		_, _ = fmt.Fprintf(tw, "%s %s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			marker, row.Local, row.Remote, row.State, row.Connections,
+			formatBytes(row.BytesSent), formatBytes(row.BytesReceived), orDash(row.Pod), orDash(row.Node))
+	}
+	_ = tw.Flush()
+	lines = append(lines, strings.Split(strings.TrimRight(b.String(), "\n"), "\n")...)
+	lines = append(lines, "", "LOGS")
+
+	if n := height - len(lines); n > 0 {
+		if n < len(logs) {
+			logs = logs[len(logs)-n:]
+		}
+		lines = append(lines, logs...)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i := range lines {
+		lines[i] = truncate(lines[i], width)
+	}
+	return lines
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatBytes returns the size in a human-readable unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
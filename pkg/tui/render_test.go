@@ -0,0 +1,51 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRender(t *testing.T) {
+	rows := []Row{
+		{Local: "127.0.0.1:15432", Remote: "db:5432", State: Forwarding, Connections: 1, BytesSent: 100, BytesReceived: 2048, Pod: "default/kubectl-external-forward-abcde", Node: "node-1"},
+		{Local: "127.0.0.1:10443", Remote: "api:443", State: Pending},
+	}
+	logs := []string{"log1", "log2", "log3"}
+	got := Render(rows, logs, 1, 120, 9)
+	want := []string{
+		header,
+		"",
+		"  LOCAL            REMOTE   STATE       CONNS  OUT   IN      POD                                     NODE",
+		"  127.0.0.1:15432  db:5432  forwarding  1      100B  2.0KiB  default/kubectl-external-forward-abcde  node-1",
+		"> 127.0.0.1:10443  api:443  pending     0      0B    0B      -                                       -",
+		"",
+		"LOGS",
+		"log2",
+		"log3",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("Truncate", func(t *testing.T) {
+		got := Render(rows, logs, 0, 10, 3)
+		want := []string{"kubectl ex", "", "  LOCAL   "}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:           "0B",
+		1023:        "1023B",
+		1024:        "1.0KiB",
+		1536 * 1024: "1.5MiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) wants %s but got %s", n, want, got)
+		}
+	}
+}
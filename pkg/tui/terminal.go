@@ -0,0 +1,128 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// refreshInterval is the interval to redraw the screen.
+const refreshInterval = 500 * time.Millisecond
+
+// key represents an input of the keyboard.
+type key int
+
+const (
+	keyUp key = iota
+	keyDown
+	keyReconnect
+	keyQuit
+)
+
+// Run shows the dashboard on the terminal until the context is canceled.
+// It calls interrupt when q or Ctrl-C is pressed.
+func (d *Dashboard) Run(ctx context.Context, in, out *os.File, interrupt func()) error {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("could not set the terminal to raw mode: %w", err)
+	}
+	defer func() {
+		_ = term.Restore(int(in.Fd()), state)
+	}()
+	// switch to the alternate screen and hide the cursor
+	_, _ = fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		_, _ = fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+	}()
+
+	keys := make(chan key)
+	done := make(chan struct{})
+	defer close(done)
+	go readKeys(in, keys, done)
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	var selected int
+	for {
+		rows := d.Rows()
+		if selected >= len(rows) {
+			selected = len(rows) - 1
+		}
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		lines := Render(rows, d.Logs(height), selected, width, height)
+		// lines are separated by CRLF in raw mode
+		_, _ = fmt.Fprint(out, "\x1b[H\x1b[2J"+strings.Join(lines, "\r\n"))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case k := <-keys:
+			switch k {
+			case keyUp:
+				if selected > 0 {
+					selected--
+				}
+			case keyDown:
+				if selected < len(rows)-1 {
+					selected++
+				}
+			case keyReconnect:
+				d.RequestReconnect(selected)
+			case keyQuit:
+				interrupt()
+			}
+		}
+	}
+}
+
+// readKeys reads the keyboard and sends the keys.
+// It returns when the input is closed or done is closed.
+// Note that it is blocked in the read until the next input after done is closed.
+func readKeys(in io.Reader, keys chan<- key, done <-chan struct{}) {
+	b := make([]byte, 16)
+	for {
+		n, err := in.Read(b)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(b[:n]) {
+			select {
+			case keys <- k:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// parseKeys returns the keys in the input.
+func parseKeys(b []byte) []key {
+	var keys []key
+	for i := 0; i < len(b); i++ {
+		switch {
+		case strings.HasPrefix(string(b[i:]), "\x1b[A"):
+			keys = append(keys, keyUp)
+			i += 2
+		case strings.HasPrefix(string(b[i:]), "\x1b[B"):
+			keys = append(keys, keyDown)
+			i += 2
+		case b[i] == 'k':
+			keys = append(keys, keyUp)
+		case b[i] == 'j':
+			keys = append(keys, keyDown)
+		case b[i] == 'r':
+			keys = append(keys, keyReconnect)
+		case b[i] == 'q', b[i] == 0x03: // Ctrl-C
+			keys = append(keys, keyQuit)
+		}
+	}
+	return keys
+}
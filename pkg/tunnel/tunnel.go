@@ -1,8 +1,176 @@
 package tunnel
 
+import (
+	"strings"
+	"time"
+)
+
 type Tunnel struct {
-	LocalHost  string
-	LocalPort  int
-	RemoteHost string
-	RemotePort int
+	// Context is the name of kubeconfig context.
+	// If empty, the current context is used.
+	Context string
+	// Namespace is the namespace of the proxy pod.
+	// If empty, the default namespace of the context is used.
+	Namespace string
+
+	LocalHost string
+	LocalPort int
+	// ContainerPort is the port which Envoy listens on in the pod.
+	// If zero, LocalPort is used.
+	ContainerPort int
+	RemoteHost    string
+	RemotePort    int
+
+	// DiscoveryType is the service discovery type of Envoy cluster.
+	// If empty, LOGICAL_DNS is used.
+	DiscoveryType string
+	// Endpoints are the upstream hosts.
+	// If empty, RemoteHost and RemotePort are used.
+	Endpoints []Endpoint
+
+	// LBPolicy is the load balancing policy of Envoy cluster, such as ROUND_ROBIN.
+	// If empty, the default policy of Envoy is used.
+	LBPolicy string
+	// HealthCheck is the active health check of the upstream hosts.
+	// If nil, no health check is performed.
+	HealthCheck *HealthCheck
+	// OutlierDetection enables ejection of the consecutively failed upstream hosts.
+	OutlierDetection bool
+
+	ConnectionOptions
+}
+
+// ConnectionOptions represents the tuning of connections.
+// Zero value means the default of Envoy.
+type ConnectionOptions struct {
+	// ConnectTimeout is the timeout for connecting to an upstream host.
+	// If zero, 30s is used.
+	ConnectTimeout time.Duration
+	// IdleTimeout is the timeout to close an idle connection.
+	IdleTimeout time.Duration
+	// MaxConnections is the maximum number of connections to the upstream hosts.
+	MaxConnections int
+	// MaxPendingRequests is the maximum number of connections waiting for the upstream hosts.
+	MaxPendingRequests int
+	// TCPKeepalive enables TCP keepalive on the connections to the upstream hosts.
+	TCPKeepalive bool
+}
+
+// WithDefaults returns the options which zero fields are set to the defaults.
+func (o ConnectionOptions) WithDefaults(defaults ConnectionOptions) ConnectionOptions {
+	if o.ConnectTimeout == 0 {
+		o.ConnectTimeout = defaults.ConnectTimeout
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = defaults.IdleTimeout
+	}
+	if o.MaxConnections == 0 {
+		o.MaxConnections = defaults.MaxConnections
+	}
+	if o.MaxPendingRequests == 0 {
+		o.MaxPendingRequests = defaults.MaxPendingRequests
+	}
+	if !o.TCPKeepalive {
+		o.TCPKeepalive = defaults.TCPKeepalive
+	}
+	return o
+}
+
+// Endpoint represents an upstream host of a tunnel.
+type Endpoint struct {
+	Host string
+	Port int
+}
+
+// HealthCheck represents an active health check of Envoy cluster.
+type HealthCheck struct {
+	// Type is either tcp or http
+	Type string
+	// Path is the request path of http health check
+	Path string
+}
+
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+)
+
+// LBPolicies are the supported load balancing policies of Envoy cluster.
+// RING_HASH and MAGLEV are not supported, because TCP proxy has no key to hash.
+// All connections come from the port-forwarder, so hashing the source address is meaningless.
+var LBPolicies = []string{"ROUND_ROBIN", "LEAST_REQUEST", "RANDOM"}
+
+// PodPort returns the port which Envoy listens on in the pod.
+func (t Tunnel) PodPort() int {
+	if t.ContainerPort != 0 {
+		return t.ContainerPort
+	}
+	return t.LocalPort
+}
+
+// ClusterType returns the service discovery type of Envoy cluster.
+func (t Tunnel) ClusterType() string {
+	if t.DiscoveryType != "" {
+		return t.DiscoveryType
+	}
+	return "LOGICAL_DNS"
+}
+
+// Upstreams returns the upstream hosts.
+func (t Tunnel) Upstreams() []Endpoint {
+	if len(t.Endpoints) > 0 {
+		return t.Endpoints
+	}
+	return []Endpoint{{Host: t.RemoteHost, Port: t.RemotePort}}
+}
+
+// IsSRV returns true if the remote host is a name of SRV record, such as _postgres._tcp.db.internal.
+func (t Tunnel) IsSRV() bool {
+	return strings.HasPrefix(t.RemoteHost, "_")
+}
+
+const servicePrefix = "svc/"
+
+// ServiceRef returns the name and namespace of Kubernetes Service
+// if the remote host is in form of svc/NAME or svc/NAME.NAMESPACE.
+// The namespace is empty if omitted.
+func (t Tunnel) ServiceRef() (name, namespace string, ok bool) {
+	if !strings.HasPrefix(t.RemoteHost, servicePrefix) {
+		return "", "", false
+	}
+	s := strings.SplitN(strings.TrimPrefix(t.RemoteHost, servicePrefix), ".", 2)
+	if len(s) == 2 {
+		return s[0], s[1], true
+	}
+	return s[0], "", true
+}
+
+const firstAllocatedContainerPort = 10000
+
+// EnvoyAdminPort is the port which the admin interface of Envoy listens on in the pod.
+// It is not assigned to any tunnel.
+const EnvoyAdminPort = 9901
+
+// AllocateContainerPorts assigns a unique container port to each tunnel.
+// It keeps the port of the first tunnel and reassigns the duplicated ones.
+// It also reassigns a tunnel which has no port or EnvoyAdminPort.
+func AllocateContainerPorts(tunnels []Tunnel) []Tunnel {
+	reserved := map[int]bool{EnvoyAdminPort: true}
+	for _, t := range tunnels {
+		reserved[t.PodPort()] = true
+	}
+	assigned := map[int]bool{0: true, EnvoyAdminPort: true}
+	next := firstAllocatedContainerPort
+	var allocated []Tunnel
+	for _, t := range tunnels {
+		if assigned[t.PodPort()] {
+			for reserved[next] || assigned[next] {
+				next++
+			}
+			t.ContainerPort = next
+		}
+		assigned[t.PodPort()] = true
+		allocated = append(allocated, t)
+	}
+	return allocated
 }
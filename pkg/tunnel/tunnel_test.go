@@ -0,0 +1,51 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAllocateContainerPorts(t *testing.T) {
+	t.Run("Duplicated", func(t *testing.T) {
+		got := AllocateContainerPorts([]Tunnel{
+			{LocalHost: "127.0.0.2", LocalPort: 5432},
+			{LocalHost: "127.0.0.3", LocalPort: 5432},
+			{LocalHost: "127.0.0.1", LocalPort: 10000},
+		})
+		want := []Tunnel{
+			{LocalHost: "127.0.0.2", LocalPort: 5432},
+			{LocalHost: "127.0.0.3", LocalPort: 5432, ContainerPort: 10001},
+			{LocalHost: "127.0.0.1", LocalPort: 10000},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("EnvoyAdminPort", func(t *testing.T) {
+		got := AllocateContainerPorts([]Tunnel{
+			{LocalHost: "127.0.0.1", LocalPort: EnvoyAdminPort},
+		})
+		want := []Tunnel{
+			{LocalHost: "127.0.0.1", LocalPort: EnvoyAdminPort, ContainerPort: 10000},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("NoPort", func(t *testing.T) {
+		got := AllocateContainerPorts([]Tunnel{
+			{LocalHost: "127.0.0.1"},
+			{LocalHost: "127.0.0.1"},
+		})
+		want := []Tunnel{
+			{LocalHost: "127.0.0.1", ContainerPort: 10000},
+			{LocalHost: "127.0.0.1", ContainerPort: 10001},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}